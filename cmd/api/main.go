@@ -10,8 +10,12 @@ import (
 	"backend/internal/users"
 	"backend/pkg/config"
 	"backend/pkg/database"
+	"backend/pkg/mailer"
 	"backend/pkg/middleware"
+	"backend/pkg/oauth"
 	"backend/pkg/response"
+	"backend/pkg/security"
+	"backend/pkg/sessions"
 
 	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
@@ -20,6 +24,7 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/session"
 	"gorm.io/gorm"
 )
 
@@ -33,6 +38,36 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Connect to Redis, it holds the sessions, their per-user index and the failed login and password counters
+	storage := config.SetupRedisStorage()
+	store := config.SetupSessionStore(storage)
+	if store == nil {
+		log.Fatal("Failed to setup session store")
+	}
+	sessionRegistry := sessions.NewRegistry(store, storage.Conn())
+	loginGuard := security.NewLoginGuard(storage.Conn(), cfg)
+
+	// Rules of new passwords, checked by the "password" validation tag and by the password services
+	passwordPolicy, err := security.NewPasswordPolicy(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	middleware.SetPasswordPolicy(passwordPolicy)
+
+	// OAuth providers, shared by the sign in and the linking of accounts
+	oauthProviders := oauth.LoadRegistry(config.LoadOAuthConfig(oauth.Registered()), config.LoadOIDCConfig())
+
+	// Outgoing emails
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	templates, err := mailer.NewTemplates(cfg.Mail.DefaultLocale)
+	if err != nil {
+		log.Fatal(err)
+	}
+	outbox := mailer.NewOutbox(mail, templates)
+
 	// Fiber instance
 	app := fiber.New(fiber.Config{
 		ErrorHandler: customErrorHandler,
@@ -41,10 +76,10 @@ func main() {
 	})
 
 	// Middlewares
-	setupMiddlewares(app)
+	setupMiddlewares(app, cfg, store, sessionRegistry)
 
 	// Routes
-	setupRoutes(app, cfg, db)
+	setupRoutes(app, cfg, db, sessionRegistry, storage, outbox, loginGuard, passwordPolicy, oauthProviders)
 
 	// Graceful shutdown
 	c := make(chan os.Signal, 1)
@@ -61,19 +96,30 @@ func main() {
 }
 
 // setupRoutes initializes all routes for the application
-func setupRoutes(app *fiber.App, cfg *config.Config, db *gorm.DB) {
+func setupRoutes(
+	app *fiber.App,
+	cfg *config.Config,
+	db *gorm.DB,
+	sessionRegistry *sessions.Registry,
+	storage fiber.Storage,
+	outbox *mailer.Outbox,
+	loginGuard *security.LoginGuard,
+	passwordPolicy *security.PasswordPolicy,
+	oauthProviders *oauth.Registry,
+) {
 	api := app.Group(fmt.Sprintf("/api/%s", strings.ToLower(cfg.Env)))
 
 	api.Get("/health", func(c *fiber.Ctx) error {
 		return c.SendString("OK")
 	})
 
-	users.RegisterAuthRoutes(api, cfg, db)
-	users.RegisterUserRoutes(api, cfg, db)
+	users.RegisterAuthRoutes(api, cfg, db, sessionRegistry, outbox, loginGuard, passwordPolicy, oauthProviders)
+	users.RegisterUserRoutes(api, cfg, db, sessionRegistry, storage, outbox, loginGuard, passwordPolicy, oauthProviders)
+	users.RegisterAdminRoutes(api, cfg, db, sessionRegistry, loginGuard)
 }
 
 // setupMiddlewares initializes all mandatory middlewares for the application
-func setupMiddlewares(app *fiber.App) {
+func setupMiddlewares(app *fiber.App, cfg *config.Config, store *session.Store, sessionRegistry *sessions.Registry) {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:3000",
 		AllowMethods:     "GET,POST,PUT,DELETE,PATCH,OPTIONS",
@@ -91,11 +137,8 @@ func setupMiddlewares(app *fiber.App) {
 		LimiterMiddleware: limiter.SlidingWindow{},
 	}))
 
-	store := config.SetupSessionStore()
-	if store == nil {
-		log.Fatal("Failed to setup session store")
-	}
-	app.Use(middleware.HandleSession(store))
+	app.Use(middleware.HandleSession(store, sessionRegistry))
+	app.Use(middleware.HandleBearerToken(cfg.JWTSecret))
 }
 
 // customErrorHandler allows for a standardized error response
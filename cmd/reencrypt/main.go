@@ -0,0 +1,119 @@
+// Command reencrypt rewrites the encrypted columns with the current key of the keyring.
+// Run it after pointing ENCRYPTION_KEY_ID at a new key, the old key can be dropped once it reports no failure.
+//
+//	go run ./cmd/reencrypt [-dry-run] [-plaintext]
+package main
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/database"
+	"backend/pkg/security"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// batchSize is the number of rows read at once from a table
+const batchSize = 500
+
+// stats counts what happened to the values of a column
+type stats struct {
+	current     int // Already encrypted with the current key
+	reencrypted int
+	failed      int // Neither decryptable nor accepted as plaintext
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would be re-encrypted without writing")
+	plaintext := flag.Bool("plaintext", false, "encrypt the values that aren't ciphertext as is, for rows written before the column was encrypted")
+	flag.Parse()
+
+	cfg := config.LoadConfig()
+
+	keyring, err := security.NewKeyring(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.ConnectDB(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	columns, err := database.EncryptedColumns(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := 0
+	for _, column := range columns {
+		result, err := reencryptColumn(db, keyring, column, *plaintext, *dryRun)
+		if err != nil {
+			log.Fatalf("%s.%s: %v", column.Table, column.Column, err)
+		}
+		log.Printf("%s.%s: %d re-encrypted, %d already current, %d failed",
+			column.Table, column.Column, result.reencrypted, result.current, result.failed)
+		failed += result.failed
+	}
+
+	if failed > 0 {
+		log.Fatalf("%d values could not be decrypted, keep the old keys until they are fixed", failed)
+	}
+}
+
+// reencryptColumn goes through the non-empty values of a column in batches, bypassing the serializer.
+// A value is only replaced if it didn't change in the meantime.
+func reencryptColumn(db *gorm.DB, keyring *security.Keyring, column database.EncryptedColumn, plaintext, dryRun bool) (stats, error) {
+	var result stats
+	selectQuery := fmt.Sprintf(`SELECT id, %q AS value FROM %q WHERE %q <> '' AND id > ? ORDER BY id LIMIT ?`,
+		column.Column, column.Table, column.Column)
+	updateQuery := fmt.Sprintf(`UPDATE %q SET %q = ? WHERE id = ? AND %q = ?`,
+		column.Table, column.Column, column.Column)
+
+	lastID := uuid.Nil
+	for {
+		var rows []struct {
+			ID    uuid.UUID
+			Value string
+		}
+		if err := db.Raw(selectQuery, lastID, batchSize).Scan(&rows).Error; err != nil {
+			return result, err
+		}
+		if len(rows) == 0 {
+			return result, nil
+		}
+
+		for _, row := range rows {
+			lastID = row.ID
+			if keyring.IsCurrent(row.Value) {
+				result.current++
+				continue
+			}
+
+			value, err := keyring.Decrypt(row.Value)
+			if err != nil {
+				// A ciphertext that doesn't decrypt needs its key back, encrypting it again would lose it for good
+				if !plaintext || keyring.IsEncrypted(row.Value) {
+					log.Printf("%s.%s: row %s: %v", column.Table, column.Column, row.ID, err)
+					result.failed++
+					continue
+				}
+				value = row.Value
+			}
+
+			if !dryRun {
+				encrypted, err := keyring.Encrypt(value)
+				if err != nil {
+					return result, err
+				}
+				if err := db.Exec(updateQuery, encrypted, row.ID, row.Value).Error; err != nil {
+					return result, err
+				}
+			}
+			result.reencrypted++
+		}
+	}
+}
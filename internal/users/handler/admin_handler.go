@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"backend/internal/users/repository"
+	"backend/internal/users/service"
+	"backend/pkg/config"
+	"backend/pkg/middleware"
+	"backend/pkg/response"
+	"backend/pkg/security"
+	"backend/pkg/sessions"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AdminHandler serves the account management routes reserved to admins
+type AdminHandler struct {
+	userService     service.UserService
+	lockoutService  service.LockoutService
+	tokenService    service.TokenService
+	sessionRegistry *sessions.Registry
+}
+
+func NewAdminHandler(
+	userService service.UserService,
+	lockoutService service.LockoutService,
+	tokenService service.TokenService,
+	sessionRegistry *sessions.Registry,
+) *AdminHandler {
+	return &AdminHandler{
+		userService:     userService,
+		lockoutService:  lockoutService,
+		tokenService:    tokenService,
+		sessionRegistry: sessionRegistry,
+	}
+}
+
+func InitAdminHandler(cfg *config.Config, db *gorm.DB, sessionRegistry *sessions.Registry, loginGuard *security.LoginGuard) *AdminHandler {
+	userRepo := repository.NewUserRepository(db)
+	userService := service.NewUserService(userRepo)
+	lockoutService := service.NewLockoutService(loginGuard, repository.NewSecurityEventRepository(db))
+	tokenService := service.NewTokenService(
+		userRepo,
+		repository.NewRefreshTokenRepository(db),
+		cfg.JWTSecret,
+		cfg.AccessTokenTTL,
+		cfg.RefreshTokenTTL,
+	)
+	return NewAdminHandler(userService, lockoutService, tokenService, sessionRegistry)
+}
+
+// UnlockUser lifts the failed login and two-factor lockouts of a user before they expire
+func (h *AdminHandler) UnlockUser(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	user, err := h.userService.GetByID(c.Context(), userID)
+	if err != nil {
+		return response.Error(c, fiber.StatusNotFound, "User not found")
+	}
+
+	reason := "admin:" + principal.UserID.String()
+	if err := h.lockoutService.Unlock(c.Context(), user, reason); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+	if err := h.lockoutService.UnlockSecondFactor(c.Context(), user, reason); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, nil)
+}
+
+// GetSecurityEvents lists the security events of a user, most recent first
+func (h *AdminHandler) GetSecurityEvents(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	events, err := h.lockoutService.Events(c.Context(), userID)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, events)
+}
+
+// RevokeUserSessions signs a user out everywhere: browser sessions and refresh tokens.
+// Access tokens already issued stay valid until they expire.
+func (h *AdminHandler) RevokeUserSessions(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	if _, err := h.userService.GetByID(c.Context(), userID); err != nil {
+		return response.Error(c, fiber.StatusNotFound, "User not found")
+	}
+
+	if err := h.sessionRegistry.RevokeAll(c.Context(), userID); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to revoke sessions")
+	}
+
+	if err := h.tokenService.RevokeAll(c.Context(), userID); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, nil)
+}
@@ -5,90 +5,426 @@ import (
 	"backend/internal/users/repository"
 	"backend/internal/users/service"
 	"backend/pkg/config"
+	"backend/pkg/mailer"
+	"backend/pkg/middleware"
 	"backend/pkg/models"
+	"backend/pkg/oauth"
 	"backend/pkg/response"
+	"backend/pkg/security"
+	"backend/pkg/sessions"
+	"backend/pkg/utils"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+const (
+	// mfaChallengeTTL is how long a password login waits for its second factor
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaMaxAttempts is the number of wrong codes after which the login has to start over
+	mfaMaxAttempts = 5
+)
+
+// Second factors a pending login can be completed with
+const (
+	mfaMethodTOTP    = "totp"
+	mfaMethodPasskey = "webauthn"
+)
+
 type AuthHandler struct {
-	authService service.AuthService
-	userService service.UserService
+	cfg                 *config.Config
+	authService         service.AuthService
+	userService         service.UserService
+	tokenService        service.TokenService
+	verificationService service.VerificationService
+	passwordService     service.PasswordService
+	twoFactorService    service.TwoFactorService
+	passkeyService      service.PasskeyService
+	magicLinkService    service.MagicLinkService
+	lockoutService      service.LockoutService
+	accountService      service.AccountService
+	sessionRegistry     *sessions.Registry
 }
 
-func NewAuthHandler(authService service.AuthService, userService service.UserService) *AuthHandler {
+func NewAuthHandler(
+	cfg *config.Config,
+	authService service.AuthService,
+	userService service.UserService,
+	tokenService service.TokenService,
+	verificationService service.VerificationService,
+	passwordService service.PasswordService,
+	twoFactorService service.TwoFactorService,
+	passkeyService service.PasskeyService,
+	magicLinkService service.MagicLinkService,
+	lockoutService service.LockoutService,
+	accountService service.AccountService,
+	sessionRegistry *sessions.Registry,
+) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
-		userService: userService,
+		cfg:                 cfg,
+		authService:         authService,
+		userService:         userService,
+		tokenService:        tokenService,
+		verificationService: verificationService,
+		passwordService:     passwordService,
+		twoFactorService:    twoFactorService,
+		passkeyService:      passkeyService,
+		magicLinkService:    magicLinkService,
+		lockoutService:      lockoutService,
+		accountService:      accountService,
+		sessionRegistry:     sessionRegistry,
 	}
 }
 
-func InitAuthHandler(cfg *config.Config, db *gorm.DB) *AuthHandler {
+func InitAuthHandler(
+	cfg *config.Config,
+	db *gorm.DB,
+	sessionRegistry *sessions.Registry,
+	outbox *mailer.Outbox,
+	loginGuard *security.LoginGuard,
+	passwordPolicy *security.PasswordPolicy,
+	oauthProviders *oauth.Registry,
+) *AuthHandler {
 	userRepo := repository.NewUserRepository(db)
 	accountRepo := repository.NewAccountRepository(db)
+	tokenRepo := repository.NewRefreshTokenRepository(db)
+	oneTimeTokenRepo := repository.NewOneTimeTokenRepository(db)
 	userService := service.NewUserService(userRepo)
+	lockoutService := service.NewLockoutService(loginGuard, repository.NewSecurityEventRepository(db))
+	hasher := newPasswordHasher(cfg)
 	authService := service.NewAuthService(
 		userRepo,
 		accountRepo,
+		lockoutService,
+		hasher,
+		cfg.OAuthEmailLinking,
+		oauthProviders,
+	)
+	tokenService := service.NewTokenService(
+		userRepo,
+		tokenRepo,
+		cfg.JWTSecret,
+		cfg.AccessTokenTTL,
+		cfg.RefreshTokenTTL,
+	)
+
+	notifier := service.NewMailNotifier(outbox, cfg.Frontend.URL)
+	verificationService := service.NewVerificationService(
+		userRepo,
+		oneTimeTokenRepo,
+		notifier,
+		cfg.JWTSecret,
+	)
+	passwordService := service.NewPasswordService(
+		userRepo,
+		accountRepo,
+		oneTimeTokenRepo,
+		tokenRepo,
+		notifier,
+		hasher,
+		passwordPolicy,
+		cfg.JWTSecret,
+	)
+	twoFactorService := service.NewTwoFactorService(
+		userRepo,
+		repository.NewTwoFactorRepository(db),
+		cfg.AppName,
+	)
+	passkeyService, err := service.NewPasskeyService(
+		userRepo,
+		accountRepo,
+		cfg.WebAuthn.RPID,
+		cfg.AppName,
+		cfg.WebAuthn.RPOrigins,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	magicLinkService := service.NewMagicLinkService(
+		userRepo,
+		oneTimeTokenRepo,
+		notifier,
+		cfg.JWTSecret,
+		cfg.MagicLinkSignUp,
 	)
 
-	return NewAuthHandler(authService, userService)
+	return NewAuthHandler(
+		cfg,
+		authService,
+		userService,
+		tokenService,
+		verificationService,
+		passwordService,
+		twoFactorService,
+		passkeyService,
+		magicLinkService,
+		lockoutService,
+		service.NewAccountService(accountRepo, userRepo, oauthProviders),
+		sessionRegistry,
+	)
+}
+
+// newPasswordHasher builds the password hasher configured in cfg
+func newPasswordHasher(cfg *config.Config) *utils.PasswordHasher {
+	return utils.NewPasswordHasher(
+		cfg.Password.Algorithm,
+		utils.Argon2Params{
+			Memory:      uint32(cfg.Password.Argon2Memory),
+			Iterations:  uint32(cfg.Password.Argon2Iterations),
+			Parallelism: uint8(cfg.Password.Argon2Parallelism),
+		},
+		cfg.Password.BcryptCost,
+	)
 }
 
 func (h *AuthHandler) Register(c *fiber.Ctx) error {
 	req := c.Locals("payload").(*dto.RegisterRequest)
 
 	user := &models.User{
-		Name:  req.Name,
-		Email: req.Email,
+		Name:   req.Name,
+		Email:  req.Email,
+		Locale: req.Locale,
 	}
 
 	if err := h.authService.Register(c.Context(), user, req.Password); err != nil {
 		return response.Error(c, fiber.StatusInternalServerError, err.Error())
 	}
 
-	err := h.authService.Login(c.Context(), req.Email, req.Password)
-	if err != nil {
-		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	// The account is usable right away, a failed email only means the user has to ask for a new one
+	if err := h.verificationService.SendEmailVerification(c.Context(), user); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
 	}
 
-	return response.Success(c, user)
+	return response.Success(c, dto.NewUserResponse(user))
 }
 
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	req := c.Locals("payload").(*dto.LoginRequest)
 
-	err := h.authService.Login(c.Context(), req.Email, req.Password)
+	user, err := h.authService.Login(c.Context(), req.Email, req.Password, c.IP())
 	if err != nil {
-		return response.Error(c, fiber.StatusUnauthorized, "Invalid credentials")
+		return loginError(c, err)
 	}
 
-	user, err := h.userService.GetByEmail(c.Context(), req.Email)
+	mfaMethods, err := h.beginLogin(c, user, req.RememberMe)
 	if err != nil {
 		return response.Error(c, fiber.StatusInternalServerError, err.Error())
 	}
+	if len(mfaMethods) > 0 {
+		return response.Success(c, fiber.Map{"mfa_required": true, "mfa_methods": mfaMethods})
+	}
+
+	return response.Success(c, dto.NewUserResponse(user))
+}
+
+// VerifyTwoFactor completes a login left pending by beginLogin with a TOTP or recovery code
+func (h *AuthHandler) VerifyTwoFactor(c *fiber.Ctx) error {
+	req := c.Locals("payload").(*dto.TwoFactorCodeRequest)
 
-	// Get session from context
 	sess, err := c.Locals("store").(*session.Store).Get(c)
 	if err != nil {
 		return response.Error(c, fiber.StatusInternalServerError, "Failed to retreive session from locals")
 	}
 
-	// Set session data
-	sess.Set("user_id", user.ID.String())
-	sess.Set("email", user.Email)
-	sess.Set("role", user.Role)
-	sess.Set("last_activity", time.Now().Unix())
-	sess.Set("expires_at", time.Now().Add(time.Hour*24).Unix())
+	userID, ok := pendingMFAUserID(sess)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "No pending two-factor login")
+	}
 
-	if err := sess.Save(); err != nil {
-		return response.Error(c, fiber.StatusInternalServerError, "Failed to save session")
+	if err := h.verifyTOTP(c, userID, req.Code); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidTwoFactorCode):
+			return rejectMFAAttempt(c, sess, err)
+		case errors.Is(err, service.ErrTwoFactorNotEnabled):
+			return response.Error(c, fiber.StatusBadRequest, err.Error())
+		}
+		return loginError(c, err)
+	}
+
+	return h.completeMFA(c, sess, userID)
+}
+
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	req := c.Locals("payload").(*dto.VerifyEmailRequest)
+
+	user, err := h.verificationService.VerifyEmail(c.Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidVerificationToken) {
+			return response.Error(c, fiber.StatusBadRequest, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, dto.NewUserResponse(user))
+}
+
+func (h *AuthHandler) ResendVerification(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	if err := h.verificationService.ResendEmailVerification(c.Context(), principal.UserID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrEmailAlreadyVerified):
+			return response.Error(c, fiber.StatusConflict, err.Error())
+		case errors.Is(err, service.ErrVerificationThrottled):
+			return response.Error(c, fiber.StatusTooManyRequests, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, nil)
+}
+
+// ForgotPassword always answers the same way so it can't tell whether an account exists
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	req := c.Locals("payload").(*dto.ForgotPasswordRequest)
+
+	if err := h.passwordService.ForgotPassword(c.Context(), req.Email); err != nil {
+		log.Printf("Password reset request failed: %v", err)
 	}
 
-	return response.Success(c, user)
+	return response.Success(c, fiber.Map{
+		"message": "If an account exists for this email, a reset link has been sent",
+	})
+}
+
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	req := c.Locals("payload").(*dto.ResetPasswordRequest)
+
+	user, err := h.passwordService.ResetPassword(c.Context(), req.Token, req.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			return response.Error(c, fiber.StatusBadRequest, err.Error())
+		}
+		var policyErr *security.PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			return middleware.ValidationError(c, middleware.FieldErrors("password", policyErr.Violations))
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	// Whoever knew the old password must not stay logged in
+	if err := h.sessionRegistry.RevokeAll(c.Context(), user.ID); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to revoke sessions")
+	}
+
+	// Proving ownership of the email is enough to lift a lockout
+	if err := h.lockoutService.Unlock(c.Context(), user, "password_reset"); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, nil)
+}
+
+// SendMagicLink always answers the same way so it can't tell whether an account exists
+func (h *AuthHandler) SendMagicLink(c *fiber.Ctx) error {
+	req := c.Locals("payload").(*dto.MagicLinkRequest)
+
+	if err := h.magicLinkService.SendMagicLink(c.Context(), req.Email, req.Locale); err != nil {
+		log.Printf("Magic link request failed: %v", err)
+	}
+
+	return response.Success(c, fiber.Map{
+		"message": "If this email can sign in, a login link has been sent",
+	})
+}
+
+// ConsumeMagicLink signs the user in the same way Login does, including the second factor
+func (h *AuthHandler) ConsumeMagicLink(c *fiber.Ctx) error {
+	req := c.Locals("payload").(*dto.ConsumeMagicLinkRequest)
+
+	user, err := h.magicLinkService.ConsumeMagicLink(c.Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMagicLink) {
+			return response.Error(c, fiber.StatusUnauthorized, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	mfaMethods, err := h.beginLogin(c, user, req.RememberMe)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+	if len(mfaMethods) > 0 {
+		return response.Success(c, fiber.Map{"mfa_required": true, "mfa_methods": mfaMethods})
+	}
+
+	return response.Success(c, dto.NewUserResponse(user))
+}
+
+// IssueToken exchanges credentials for an access/refresh token pair, for clients that can't use cookies
+func (h *AuthHandler) IssueToken(c *fiber.Ctx) error {
+	req := c.Locals("payload").(*dto.TokenRequest)
+
+	user, err := h.authService.Login(c.Context(), req.Email, req.Password, c.IP())
+	if err != nil {
+		return loginError(c, err)
+	}
+
+	// Without a session to hold a pending login, the second factor comes with the credentials
+	mfaMethods, err := h.mfaMethods(c, user)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+	if len(mfaMethods) > 0 {
+		// A passkey assertion needs a challenge, so it can't be sent along with the credentials
+		if !slices.Contains(mfaMethods, mfaMethodTOTP) {
+			return response.Error(c, fiber.StatusUnauthorized, "Two-factor authentication with a passkey requires a session login")
+		}
+		if req.Code == "" {
+			return response.Error(c, fiber.StatusUnauthorized, "mfa_required")
+		}
+		if err := h.verifyTOTP(c, user.ID, req.Code); err != nil {
+			if errors.Is(err, service.ErrInvalidTwoFactorCode) {
+				return response.Error(c, fiber.StatusUnauthorized, err.Error())
+			}
+			return loginError(c, err)
+		}
+	}
+
+	tokens, err := h.tokenService.Issue(c.Context(), user)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, tokens)
+}
+
+func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
+	req := c.Locals("payload").(*dto.RefreshTokenRequest)
+
+	tokens, err := h.tokenService.Refresh(c.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+			return response.Error(c, fiber.StatusUnauthorized, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, tokens)
+}
+
+func (h *AuthHandler) RevokeToken(c *fiber.Ctx) error {
+	req := c.Locals("payload").(*dto.RefreshTokenRequest)
+
+	if err := h.tokenService.Revoke(c.Context(), req.RefreshToken); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, nil)
 }
 
 func (h *AuthHandler) Logout(c *fiber.Ctx) error {
@@ -97,6 +433,11 @@ func (h *AuthHandler) Logout(c *fiber.Ctx) error {
 		return response.Error(c, fiber.StatusInternalServerError, "Failed to retreive session from locals")
 	}
 
+	// The old ID is destroyed with its data, the new one is never saved
+	if err := h.sessionRegistry.Regenerate(c.Context(), sess); err != nil {
+		log.Printf("Failed to regenerate session: %v", err)
+	}
+
 	if err := sess.Destroy(); err != nil {
 		return response.Error(c, fiber.StatusInternalServerError, "Failed to destroy session")
 	}
@@ -114,14 +455,33 @@ func (h *AuthHandler) Logout(c *fiber.Ctx) error {
 	return response.Success(c, nil)
 }
 
+// OAuthSignIn redirects to the provider, ?remember_me=true asks for a long-lived session once back
 func (h *AuthHandler) OAuthSignIn(c *fiber.Ctx) error {
 	provider := c.Params("provider")
 
-	redirectURL, err := h.authService.GetOAuthRedirectURL(provider)
+	returnTo, err := resolveReturnTo(h.cfg, c.Query("return_to"))
 	if err != nil {
 		return response.Error(c, fiber.StatusBadRequest, err.Error())
 	}
 
+	redirectURL, pending, err := h.authService.GetOAuthRedirectURL(provider)
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	sess, err := c.Locals("store").(*session.Store).Get(c)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to retreive session from locals")
+	}
+
+	storePendingOAuthState(sess, pending, returnTo)
+	sess.Set("oauth_remember_me", c.QueryBool("remember_me"))
+	sess.Delete("oauth_link_user_id")
+
+	if err := sess.Save(); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to save session")
+	}
+
 	return c.Redirect(redirectURL)
 }
 
@@ -130,20 +490,424 @@ func (h *AuthHandler) OAuthCallback(c *fiber.Ctx) error {
 	code := c.Query("code")
 	state := c.Query("state")
 
-	user, err := h.authService.HandleOAuthCallback(c.Context(), provider, code, state)
+	sess, err := c.Locals("store").(*session.Store).Get(c)
 	if err != nil {
-		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to retreive session from locals")
+	}
+
+	// The pending login is removed before it is checked so a state can only be used once
+	pending := pendingOAuthState(sess)
+	returnTo, ok := sess.Get("oauth_return_to").(string)
+	if !ok || returnTo == "" {
+		returnTo = h.cfg.Frontend.URL
 	}
+	rememberMe, _ := sess.Get("oauth_remember_me").(bool)
+	linkUserID, _ := sess.Get("oauth_link_user_id").(string)
+	sess.Delete("oauth_provider")
+	sess.Delete("oauth_state")
+	sess.Delete("oauth_verifier")
+	sess.Delete("oauth_nonce")
+	sess.Delete("oauth_expires_at")
+	sess.Delete("oauth_return_to")
+	sess.Delete("oauth_remember_me")
+	sess.Delete("oauth_link_user_id")
 
-	return response.Success(c, user)
+	if err := sess.Save(); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to save session")
+	}
+
+	// The provider reports a refused consent or its own failures in the query string
+	if providerError := c.Query("error"); providerError != "" {
+		return redirectWithError(c, returnTo, providerError, c.Query("error_description"))
+	}
+
+	// The flow was started by UserHandler.LinkAccount
+	if linkUserID != "" {
+		return h.finishOAuthLink(c, linkUserID, provider, code, state, pending, returnTo)
+	}
+
+	user, err := h.authService.HandleOAuthCallback(c.Context(), provider, code, state, pending)
+	if err != nil {
+		if isOAuthRequestError(err) {
+			return redirectWithError(c, returnTo, "invalid_request", err.Error())
+		}
+		if errors.Is(err, service.ErrOAuthEmailInUse) {
+			return redirectWithError(c, returnTo, "email_in_use", err.Error())
+		}
+		if errors.Is(err, oauth.ErrEmailUnavailable) {
+			return redirectWithError(c, returnTo, "email_unavailable", err.Error())
+		}
+		log.Printf("OAuth callback failed: %v", err)
+		return redirectWithError(c, returnTo, "server_error", "OAuth sign in failed")
+	}
+
+	mfaMethods, err := h.beginLogin(c, user, rememberMe)
+	if err != nil {
+		log.Printf("OAuth callback failed: %v", err)
+		return redirectWithError(c, returnTo, "server_error", err.Error())
+	}
+	if len(mfaMethods) > 0 {
+		return redirectWithParams(c, returnTo, url.Values{"mfa_required": {"true"}})
+	}
+
+	return c.Redirect(returnTo)
+}
+
+// finishOAuthLink adds the provider identity to the user who started the link, who must still be signed in on this session
+func (h *AuthHandler) finishOAuthLink(
+	c *fiber.Ctx,
+	linkUserID, provider, code, state string,
+	pending *service.OAuthState,
+	returnTo string,
+) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok || principal.Method != middleware.AuthMethodSession || principal.UserID.String() != linkUserID {
+		return redirectWithError(c, returnTo, "access_denied", "Sign in again to link an account")
+	}
+
+	if _, err := h.accountService.LinkOAuthAccount(c.Context(), principal.UserID, provider, code, state, pending); err != nil {
+		switch {
+		case isOAuthRequestError(err):
+			return redirectWithError(c, returnTo, "invalid_request", err.Error())
+		case errors.Is(err, service.ErrAccountLinkedElsewhere):
+			return redirectWithError(c, returnTo, "account_linked_elsewhere", err.Error())
+		case errors.Is(err, oauth.ErrEmailUnavailable):
+			return redirectWithError(c, returnTo, "email_unavailable", err.Error())
+		}
+		log.Printf("OAuth link failed: %v", err)
+		return redirectWithError(c, returnTo, "server_error", "OAuth link failed")
+	}
+
+	return redirectWithParams(c, returnTo, url.Values{"linked": {provider}})
 }
 
+// CheckSession describes the signed in user, an anonymous visitor gets the same fields left empty
 func (h *AuthHandler) CheckSession(c *fiber.Ctx) error {
-	return response.Success(c, fiber.Map{
-		"user_id":       c.Locals("user_id"),
-		"email":         c.Locals("email"),
-		"role":          c.Locals("role"),
+	data := fiber.Map{
+		"user_id":       nil,
+		"email":         nil,
+		"role":          nil,
 		"last_activity": c.Locals("last_activity"),
 		"expires_at":    c.Locals("expires_at"),
+	}
+	if principal, ok := middleware.GetPrincipal(c); ok {
+		data["user_id"] = principal.UserID
+		data["email"] = principal.Email
+		data["role"] = principal.Role
+	}
+
+	return response.Success(c, data)
+}
+
+// storePendingOAuthState keeps the state of an OAuth flow server-side so the callback can check it
+func storePendingOAuthState(sess *session.Session, pending *service.OAuthState, returnTo string) {
+	sess.Set("oauth_provider", pending.Provider)
+	sess.Set("oauth_state", pending.State)
+	sess.Set("oauth_verifier", pending.CodeVerifier)
+	sess.Set("oauth_nonce", pending.Nonce)
+	sess.Set("oauth_expires_at", pending.ExpiresAt.Unix())
+	sess.Set("oauth_return_to", returnTo)
+}
+
+// pendingOAuthState reads the pending OAuth login stored in the session by storePendingOAuthState
+func pendingOAuthState(sess *session.Session) *service.OAuthState {
+	state, ok := sess.Get("oauth_state").(string)
+	if !ok {
+		return nil
+	}
+
+	provider, _ := sess.Get("oauth_provider").(string)
+	verifier, _ := sess.Get("oauth_verifier").(string)
+	nonce, _ := sess.Get("oauth_nonce").(string)
+	expiresAt, _ := sess.Get("oauth_expires_at").(int64)
+
+	return &service.OAuthState{
+		Provider:     provider,
+		State:        state,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Unix(expiresAt, 0),
+	}
+}
+
+// loginError answers a failed password login, a blocked one tells the client when to retry
+func loginError(c *fiber.Ctx, err error) error {
+	var blocked *security.BlockedError
+	switch {
+	case errors.As(err, &blocked):
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+		return response.Error(c, fiber.StatusTooManyRequests, blocked.Error())
+	case errors.Is(err, service.ErrInvalidCredentials):
+		return response.Error(c, fiber.StatusUnauthorized, "Invalid credentials")
+	}
+	return response.Error(c, fiber.StatusInternalServerError, err.Error())
+}
+
+// isOAuthRequestError reports whether the callback failed because of the request itself
+func isOAuthRequestError(err error) bool {
+	return errors.Is(err, service.ErrOAuthCodeMissing) ||
+		errors.Is(err, service.ErrOAuthStateMissing) ||
+		errors.Is(err, service.ErrOAuthStateNotFound) ||
+		errors.Is(err, service.ErrOAuthStateExpired) ||
+		errors.Is(err, service.ErrOAuthStateMismatch) ||
+		errors.Is(err, service.ErrOAuthProviderMismatch)
+}
+
+// beginLogin creates the session of a user who passed the first factor. When the user has a second
+// factor, it only stores a pending challenge and returns the methods that can complete it.
+func (h *AuthHandler) beginLogin(c *fiber.Ctx, user *models.User, rememberMe bool) ([]string, error) {
+	methods, err := h.mfaMethods(c, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) == 0 {
+		return nil, h.createSession(c, user, rememberMe)
+	}
+
+	sess, err := c.Locals("store").(*session.Store).Get(c)
+	if err != nil {
+		return nil, errors.New("Failed to retreive session from locals")
+	}
+
+	// Half a login already deserves a new ID and none of the data stored before it
+	if err := h.sessionRegistry.Reset(c.Context(), sess); err != nil {
+		return nil, errors.New("Failed to regenerate session")
+	}
+
+	sess.Set("mfa_user_id", user.ID.String())
+	sess.Set("mfa_expires_at", time.Now().Add(mfaChallengeTTL).Unix())
+	sess.Set("mfa_attempts", 0)
+	sess.Set("mfa_remember_me", rememberMe)
+
+	if err := sess.Save(); err != nil {
+		return nil, errors.New("Failed to save session")
+	}
+
+	return methods, nil
+}
+
+// mfaMethods lists the second factors of a user, empty when 2FA is off. Passkeys only count once the
+// user opted in, so that registering one for the passwordless login doesn't change the password login.
+func (h *AuthHandler) mfaMethods(c *fiber.Ctx, user *models.User) ([]string, error) {
+	var methods []string
+
+	totpEnabled, err := h.twoFactorService.IsEnabled(c.Context(), user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check two-factor authentication: %w", err)
+	}
+	if totpEnabled {
+		methods = append(methods, mfaMethodTOTP)
+	}
+
+	if !user.PasskeySecondFactor {
+		return methods, nil
+	}
+
+	hasPasskeys, err := h.passkeyService.HasPasskeys(c.Context(), user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check passkeys: %w", err)
+	}
+	if hasPasskeys {
+		methods = append(methods, mfaMethodPasskey)
+	}
+
+	return methods, nil
+}
+
+// completeMFA turns the pending login into a full session once the second factor is verified
+func (h *AuthHandler) completeMFA(c *fiber.Ctx, sess *session.Session, userID uuid.UUID) error {
+	rememberMe, _ := sess.Get("mfa_remember_me").(bool)
+	clearMFAChallenge(sess)
+	if err := sess.Save(); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to save session")
+	}
+
+	user, err := h.userService.GetByID(c.Context(), userID)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err := h.createSession(c, user, rememberMe); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, dto.NewUserResponse(user))
+}
+
+// pendingMFAUserID returns the user of the pending two-factor login, an expired one is discarded
+func pendingMFAUserID(sess *session.Session) (uuid.UUID, bool) {
+	rawUserID, _ := sess.Get("mfa_user_id").(string)
+	expiresAt, _ := sess.Get("mfa_expires_at").(int64)
+
+	userID, err := uuid.Parse(rawUserID)
+	if err != nil || time.Now().Unix() > expiresAt {
+		clearMFAChallenge(sess)
+		_ = sess.Save()
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// verifyTOTP checks the TOTP or recovery code of a login under the same lockout as the password
+func (h *AuthHandler) verifyTOTP(c *fiber.Ctx, userID uuid.UUID, code string) error {
+	return checkSecondFactor(c, h.lockoutService, h.userService, userID, func() error {
+		return h.twoFactorService.Verify(c.Context(), userID, code)
 	})
 }
+
+// checkSecondFactor runs verify, a check of a TOTP or recovery code, under the second factor lockout.
+// The failures are counted per user so they add up across sessions, token requests and account settings.
+func checkSecondFactor(
+	c *fiber.Ctx,
+	lockoutService service.LockoutService,
+	userService service.UserService,
+	userID uuid.UUID,
+	verify func() error,
+) error {
+	if err := lockoutService.CheckSecondFactor(c.Context(), userID, c.IP()); err != nil {
+		return err
+	}
+
+	err := verify()
+	if errors.Is(err, service.ErrInvalidTwoFactorCode) {
+		user, findErr := userService.GetByID(c.Context(), userID)
+		if findErr != nil {
+			return findErr
+		}
+		if recordErr := lockoutService.RecordSecondFactorFailure(c.Context(), user, c.IP()); recordErr != nil {
+			return recordErr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	return lockoutService.RecordSecondFactorSuccess(c.Context(), userID)
+}
+
+// rejectMFAAttempt counts a failed second factor, too many of them send the user back to the first step
+func rejectMFAAttempt(c *fiber.Ctx, sess *session.Session, reason error) error {
+	attempts, _ := sess.Get("mfa_attempts").(int)
+	if attempts+1 >= mfaMaxAttempts {
+		clearMFAChallenge(sess)
+	} else {
+		sess.Set("mfa_attempts", attempts+1)
+	}
+
+	if err := sess.Save(); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to save session")
+	}
+	return response.Error(c, fiber.StatusUnauthorized, reason.Error())
+}
+
+// clearMFAChallenge removes the pending two-factor login from the session
+func clearMFAChallenge(sess *session.Session) {
+	sess.Delete("mfa_user_id")
+	sess.Delete("mfa_expires_at")
+	sess.Delete("mfa_attempts")
+	sess.Delete("mfa_remember_me")
+}
+
+// createSession logs the user in on the current session, every sign in method goes through it.
+// A "remember me" session only ends after a long inactivity, others also end a fixed time after the login.
+func (h *AuthHandler) createSession(c *fiber.Ctx, user *models.User, rememberMe bool) error {
+	sess, err := c.Locals("store").(*session.Store).Get(c)
+	if err != nil {
+		return errors.New("Failed to retreive session from locals")
+	}
+
+	// The visitor may have been handed its session ID by someone else, neither it nor the data
+	// stored under it must survive the login
+	if err := h.sessionRegistry.Reset(c.Context(), sess); err != nil {
+		return errors.New("Failed to regenerate session")
+	}
+
+	now := time.Now()
+	idleTimeout, lifetime := h.cfg.Session.IdleTimeout, h.cfg.Session.AbsoluteTimeout
+	if rememberMe {
+		idleTimeout, lifetime = h.cfg.Session.RememberMeTimeout, h.cfg.Session.RememberMeTimeout
+	}
+	// The store keeps the session a while after it expired, so HandleSession can report it
+	ttl := lifetime + middleware.ExpiredSessionGrace
+	sess.SetExpiry(ttl)
+
+	// Index the session under its user so it can be revoked from elsewhere
+	metadata := sessions.Metadata{UserAgent: c.Get(fiber.HeaderUserAgent), IP: c.IP()}
+	if err := h.sessionRegistry.Track(c.Context(), user.ID, sess.ID(), metadata, ttl); err != nil {
+		return errors.New("Failed to track session")
+	}
+
+	sess.Set("user_id", user.ID.String())
+	sess.Set("email", user.Email)
+	sess.Set("role", user.Role)
+	sess.Set("created_at", now.Unix())
+	sess.Set("last_activity", now.Unix())
+	sess.Set("idle_timeout", int64(idleTimeout.Seconds()))
+	sess.Set("expires_at", now.Add(lifetime).Unix())
+	sess.Set("remember_me", rememberMe)
+
+	if err := sess.Save(); err != nil {
+		return errors.New("Failed to save session")
+	}
+
+	return nil
+}
+
+// resolveReturnTo checks a return_to value against the frontend allow-list.
+// Relative paths are resolved against the frontend URL, an empty value falls back to it.
+func resolveReturnTo(cfg *config.Config, raw string) (string, error) {
+	if raw == "" {
+		return cfg.Frontend.URL, nil
+	}
+
+	target, err := url.Parse(raw)
+	if err != nil || target.User != nil || strings.Contains(raw, "\\") {
+		return "", errors.New("invalid return_to URL")
+	}
+
+	if !target.IsAbs() {
+		// Reject protocol-relative URLs such as //evil.example
+		if target.Host != "" || !strings.HasPrefix(target.Path, "/") || strings.HasPrefix(raw, "//") {
+			return "", errors.New("invalid return_to URL")
+		}
+		return strings.TrimSuffix(cfg.Frontend.URL, "/") + target.String(), nil
+	}
+
+	for _, origin := range cfg.Frontend.AllowedOrigins {
+		allowed, err := url.Parse(origin)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(allowed.Scheme, target.Scheme) && strings.EqualFold(allowed.Host, target.Host) {
+			return target.String(), nil
+		}
+	}
+
+	return "", errors.New("return_to URL is not allowed")
+}
+
+// redirectWithError sends the browser back to the frontend with the error in the query string
+func redirectWithError(c *fiber.Ctx, target, code, description string) error {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	return redirectWithParams(c, target, params)
+}
+
+// redirectWithParams sends the browser back to the frontend with params added to the query string
+func redirectWithParams(c *fiber.Ctx, target string, params url.Values) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, params.Get("error_description"))
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+
+	return c.Redirect(u.String())
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"backend/internal/users/service"
+	"backend/pkg/middleware"
+	"backend/pkg/models"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func checkSession(t *testing.T, principal *middleware.Principal) map[string]interface{} {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/session", func(c *fiber.Ctx) error {
+		if principal != nil {
+			middleware.SetPrincipal(c, principal)
+		}
+		return c.Next()
+	}, (&AuthHandler{}).CheckSession)
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/session", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Data
+}
+
+func TestCheckSessionReadsThePrincipal(t *testing.T) {
+	userID := uuid.New()
+	data := checkSession(t, &middleware.Principal{
+		UserID: userID,
+		Email:  "jane@example.com",
+		Role:   "admin",
+		Method: middleware.AuthMethodToken,
+	})
+
+	if data["user_id"] != userID.String() || data["email"] != "jane@example.com" || data["role"] != "admin" {
+		t.Errorf("CheckSession() data = %v", data)
+	}
+}
+
+func TestCheckSessionAnonymous(t *testing.T) {
+	data := checkSession(t, nil)
+
+	if data["user_id"] != nil || data["role"] != nil {
+		t.Errorf("CheckSession() data = %v, want empty fields", data)
+	}
+}
+
+type fakePasskeys struct{ service.PasskeyService }
+
+func (fakePasskeys) HasPasskeys(ctx context.Context, userID uuid.UUID) (bool, error) {
+	return true, nil
+}
+
+// A passkey registered for the passwordless login only becomes a second factor once the user opts in
+func TestMFAMethodsPasskeyIsOptIn(t *testing.T) {
+	h := &AuthHandler{twoFactorService: &fakeTwoFactor{}, passkeyService: fakePasskeys{}}
+	app := fiber.New()
+	var methods []string
+	app.Get("/", func(c *fiber.Ctx) error {
+		user := &models.User{PasskeySecondFactor: c.QueryBool("opt_in")}
+		var err error
+		methods, err = h.mfaMethods(c, user)
+		return err
+	})
+
+	for _, optIn := range []bool{false, true} {
+		if _, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/?opt_in="+strconv.FormatBool(optIn), nil)); err != nil {
+			t.Fatal(err)
+		}
+		if got := slices.Contains(methods, mfaMethodPasskey); got != optIn {
+			t.Errorf("opt in %v: mfaMethods() = %v", optIn, methods)
+		}
+	}
+}
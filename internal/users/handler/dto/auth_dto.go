@@ -3,16 +3,62 @@ package dto
 type RegisterRequest struct {
 	Name     string `json:"name" validate:"required,min=3,max=100"`
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6"`
+	Password string `json:"password" validate:"required,password"`
+	Locale   string `json:"locale,omitempty" validate:"omitempty,bcp47_language_tag"`
 }
 
 type LoginRequest struct {
+	Email      string `json:"email" validate:"required,email"`
+	Password   string `json:"password" validate:"required"`
+	RememberMe bool   `json:"remember_me,omitempty"`
+}
+
+type UpdateUserRequest struct {
+	Name        string `json:"name,omitempty" validate:"omitempty,min=3,max=100"`
+	Phone       string `json:"phone,omitempty"`
+	Image       string `json:"image,omitempty"`
+	Locale      string `json:"locale,omitempty" validate:"omitempty,bcp47_language_tag"`
+	SyncProfile *bool  `json:"sync_profile,omitempty"` // Keep the name and image in sync with the OAuth providers
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type MagicLinkRequest struct {
+	Email  string `json:"email" validate:"required,email"`
+	Locale string `json:"locale,omitempty" validate:"omitempty,bcp47_language_tag"` // Used for a user created by the link
+}
+
+type ConsumeMagicLinkRequest struct {
+	Token      string `json:"token" validate:"required"`
+	RememberMe bool   `json:"remember_me,omitempty"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+type TokenRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required"`
+	Code     string `json:"code,omitempty"` // TOTP or recovery code, required when 2FA is on
 }
 
-type UpdateUserRequest struct {
-	Name  string `json:"name,omitempty" validate:"omitempty,min=3,max=100"`
-	Phone string `json:"phone,omitempty"`
-	Image string `json:"image,omitempty"`
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,password"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
 }
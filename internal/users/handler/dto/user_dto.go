@@ -0,0 +1,103 @@
+package dto
+
+import (
+	"backend/pkg/models"
+	"backend/pkg/sessions"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserResponse is the public view of a user, it never carries credentials or provider tokens
+type UserResponse struct {
+	ID                  uuid.UUID         `json:"id"`
+	Name                string            `json:"name"`
+	Email               string            `json:"email"`
+	Image               string            `json:"image"`
+	Role                string            `json:"role"`
+	Phone               string            `json:"phone"`
+	Locale              string            `json:"locale"`
+	EmailVerifiedAt     *time.Time        `json:"email_verified_at"`
+	SyncProfile         bool              `json:"sync_profile"`
+	PasskeySecondFactor bool              `json:"passkey_second_factor"`
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+	Accounts            []AccountResponse `json:"accounts"`
+}
+
+// AccountResponse is the public view of a linked sign in method
+type AccountResponse struct {
+	ID          uuid.UUID  `json:"id"`
+	Type        string     `json:"type"`
+	Provider    string     `json:"provider,omitempty"`
+	Name        string     `json:"name,omitempty"` // Label of a passkey
+	LinkedAt    time.Time  `json:"linked_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	HasPassword bool       `json:"has_password"`
+}
+
+func NewUserResponse(user *models.User) UserResponse {
+	return UserResponse{
+		ID:                  user.ID,
+		Name:                user.Name,
+		Email:               user.Email,
+		Image:               user.Image,
+		Role:                user.Role,
+		Phone:               user.Phone,
+		Locale:              user.Locale,
+		EmailVerifiedAt:     user.EmailVerifiedAt,
+		SyncProfile:         user.SyncProfile,
+		PasskeySecondFactor: user.PasskeySecondFactor,
+		CreatedAt:           user.CreatedAt,
+		UpdatedAt:           user.UpdatedAt,
+		Accounts:            NewAccountResponses(user.Accounts),
+	}
+}
+
+func NewAccountResponse(account *models.Account) AccountResponse {
+	return AccountResponse{
+		ID:          account.ID,
+		Type:        account.Type,
+		Provider:    account.Provider,
+		Name:        account.Name,
+		LinkedAt:    account.CreatedAt,
+		LastUsedAt:  account.LastUsedAt,
+		HasPassword: account.Password != "",
+	}
+}
+
+func NewAccountResponses(accounts []models.Account) []AccountResponse {
+	responses := make([]AccountResponse, 0, len(accounts))
+	for i := range accounts {
+		responses = append(responses, NewAccountResponse(&accounts[i]))
+	}
+	return responses
+}
+
+// SessionResponse is a signed in browser of the user, Current marks the one making the request
+type SessionResponse struct {
+	ID           string    `json:"id"`
+	Device       string    `json:"device"`
+	UserAgent    string    `json:"user_agent"`
+	IP           string    `json:"ip"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+	Current      bool      `json:"current"`
+}
+
+func NewSessionResponses(infos []sessions.Info, currentSessionID string) []SessionResponse {
+	current := sessions.PublicID(currentSessionID)
+	responses := make([]SessionResponse, 0, len(infos))
+	for _, info := range infos {
+		responses = append(responses, SessionResponse{
+			ID:           info.ID,
+			Device:       info.Device,
+			UserAgent:    info.UserAgent,
+			IP:           info.IP,
+			CreatedAt:    info.CreatedAt,
+			LastActivity: info.LastActivity,
+			Current:      currentSessionID != "" && info.ID == current,
+		})
+	}
+	return responses
+}
@@ -0,0 +1,100 @@
+package dto
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/pkg/models"
+)
+
+// Values planted in every secret field, none of them may appear in a response
+var secretValues = []string{
+	"$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA",
+	"provider-access-token",
+	"provider-refresh-token",
+	"cose-public-key",
+}
+
+// Keys of secret fields, a response must not have them even empty
+var secretKeys = []string{"password", "access_token", "refresh_token", "public_key", "secret"}
+
+func userWithSecrets() *models.User {
+	now := time.Now()
+	return &models.User{
+		Name:  "Jane Doe",
+		Email: "jane@example.com",
+		Accounts: []models.Account{
+			{Type: models.AccountTypeCredentials, Password: secretValues[0]},
+			{
+				Type:         models.AccountTypeOAuth,
+				Provider:     "google",
+				AccessToken:  secretValues[1],
+				RefreshToken: secretValues[2],
+				ExpiresAt:    now,
+			},
+			{Type: models.AccountTypeWebAuthn, Provider: "webauthn", PublicKey: []byte(secretValues[3]), LastUsedAt: &now},
+		},
+	}
+}
+
+// assertNoSecrets fails when the JSON of value carries a secret key or value, at any depth
+func assertNoSecrets(t *testing.T, value interface{}) {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range secretValues {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("JSON leaks the secret %q: %s", secret, data)
+		}
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	walkKeys(decoded, func(key string) {
+		for _, secretKey := range secretKeys {
+			if strings.EqualFold(key, secretKey) {
+				t.Errorf("JSON has the secret key %q: %s", key, data)
+			}
+		}
+	})
+}
+
+func walkKeys(value interface{}, visit func(string)) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			visit(key)
+			walkKeys(child, visit)
+		}
+	case []interface{}:
+		for _, child := range v {
+			walkKeys(child, visit)
+		}
+	}
+}
+
+func TestUserResponseHasNoSecrets(t *testing.T) {
+	assertNoSecrets(t, NewUserResponse(userWithSecrets()))
+}
+
+func TestAccountResponsesHaveNoSecrets(t *testing.T) {
+	responses := NewAccountResponses(userWithSecrets().Accounts)
+	assertNoSecrets(t, responses)
+
+	if !responses[0].HasPassword {
+		t.Error("HasPassword = false for a credentials account")
+	}
+}
+
+// The models are not meant to be serialized, but a handler returning one by mistake must not leak either
+func TestModelsHaveNoSecrets(t *testing.T) {
+	assertNoSecrets(t, userWithSecrets())
+	assertNoSecrets(t, models.TwoFactor{Secret: secretValues[0]})
+}
@@ -1,60 +1,116 @@
 package handler
 
 import (
+	"errors"
 	"log"
 	"backend/internal/users/handler/dto"
 	"backend/internal/users/repository"
 	"backend/internal/users/service"
+	"backend/pkg/config"
+	"backend/pkg/mailer"
+	"backend/pkg/middleware"
+	"backend/pkg/oauth"
 	"backend/pkg/response"
+	"backend/pkg/security"
+	"backend/pkg/sessions"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type UserHandler struct {
-	userService service.UserService
+	cfg              *config.Config
+	userService      service.UserService
+	passwordService  service.PasswordService
+	twoFactorService service.TwoFactorService
+	accountService   service.AccountService
+	lockoutService   service.LockoutService
+	sessionRegistry  *sessions.Registry
 }
 
-func NewUserHandler(userService service.UserService) *UserHandler {
+func NewUserHandler(
+	cfg *config.Config,
+	userService service.UserService,
+	passwordService service.PasswordService,
+	twoFactorService service.TwoFactorService,
+	accountService service.AccountService,
+	lockoutService service.LockoutService,
+	sessionRegistry *sessions.Registry,
+) *UserHandler {
 	return &UserHandler{
-		userService: userService,
+		cfg:              cfg,
+		userService:      userService,
+		passwordService:  passwordService,
+		twoFactorService: twoFactorService,
+		accountService:   accountService,
+		lockoutService:   lockoutService,
+		sessionRegistry:  sessionRegistry,
 	}
 }
 
-func InitUserHandler(db *gorm.DB) *UserHandler {
+func InitUserHandler(
+	cfg *config.Config,
+	db *gorm.DB,
+	sessionRegistry *sessions.Registry,
+	outbox *mailer.Outbox,
+	loginGuard *security.LoginGuard,
+	passwordPolicy *security.PasswordPolicy,
+	oauthProviders *oauth.Registry,
+) *UserHandler {
 	userRepo := repository.NewUserRepository(db)
+	accountRepo := repository.NewAccountRepository(db)
 	userService := service.NewUserService(userRepo)
-	return NewUserHandler(userService)
+	passwordService := service.NewPasswordService(
+		userRepo,
+		accountRepo,
+		repository.NewOneTimeTokenRepository(db),
+		repository.NewRefreshTokenRepository(db),
+		service.NewMailNotifier(outbox, cfg.Frontend.URL),
+		newPasswordHasher(cfg),
+		passwordPolicy,
+		cfg.JWTSecret,
+	)
+	twoFactorService := service.NewTwoFactorService(
+		userRepo,
+		repository.NewTwoFactorRepository(db),
+		cfg.AppName,
+	)
+	return NewUserHandler(
+		cfg,
+		userService,
+		passwordService,
+		twoFactorService,
+		service.NewAccountService(accountRepo, userRepo, oauthProviders),
+		service.NewLockoutService(loginGuard, repository.NewSecurityEventRepository(db)),
+		sessionRegistry,
+	)
 }
 
 func (h *UserHandler) GetMe(c *fiber.Ctx) error {
-	userID := c.Locals("user_id")
-
-	userIDToString, err := uuid.Parse(userID.(string))
-	if err != nil {
-		return response.Error(c, fiber.StatusInternalServerError, "Invalid user ID format")
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
 	}
 
-	user, err := h.userService.GetByID(c.Context(), userIDToString)
+	user, err := h.userService.GetByID(c.Context(), principal.UserID)
 	if err != nil {
 		log.Println("Error: ", err)
 		return response.Error(c, fiber.StatusInternalServerError, err.Error())
 	}
 
-	return response.Success(c, user)
+	return response.Success(c, dto.NewUserResponse(user))
 }
 
 func (h *UserHandler) UpdateMe(c *fiber.Ctx) error {
-	userID := c.Locals("user_id")
-
-	userIDToString, err := uuid.Parse(userID.(string))
-	if err != nil {
-		return response.Error(c, fiber.StatusInternalServerError, "Invalid user ID format")
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
 	}
 
 	req := c.Locals("payload").(*dto.UpdateUserRequest)
-	user, err := h.userService.GetByID(c.Context(), userIDToString)
+	user, err := h.userService.GetByID(c.Context(), principal.UserID)
 	if err != nil {
 		return response.Error(c, fiber.StatusInternalServerError, err.Error())
 	}
@@ -68,10 +124,229 @@ func (h *UserHandler) UpdateMe(c *fiber.Ctx) error {
 	if req.Image != "" {
 		user.Image = req.Image
 	}
+	if req.Locale != "" {
+		user.Locale = req.Locale
+	}
+	if req.SyncProfile != nil {
+		user.SyncProfile = *req.SyncProfile
+	}
 
 	if err := h.userService.Update(c.Context(), user); err != nil {
 		return response.Error(c, fiber.StatusInternalServerError, err.Error())
 	}
 
-	return response.Success(c, user)
+	return response.Success(c, dto.NewUserResponse(user))
+}
+
+func (h *UserHandler) ChangePassword(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	req := c.Locals("payload").(*dto.ChangePasswordRequest)
+	if err := h.passwordService.ChangePassword(c.Context(), principal.UserID, req.CurrentPassword, req.NewPassword); err != nil {
+		var policyErr *security.PasswordPolicyError
+		switch {
+		case errors.As(err, &policyErr):
+			return middleware.ValidationError(c, middleware.FieldErrors("new_password", policyErr.Violations))
+		case errors.Is(err, service.ErrInvalidCredentials):
+			return response.Error(c, fiber.StatusUnauthorized, "Current password is incorrect")
+		case errors.Is(err, service.ErrNoPassword):
+			return response.Error(c, fiber.StatusBadRequest, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	// Keep the session that made the change, log out every other one
+	if err := h.sessionRegistry.RevokeAll(c.Context(), principal.UserID, principal.SessionID); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to revoke sessions")
+	}
+
+	return response.Success(c, nil)
+}
+
+// EnrollTOTP starts the 2FA enrollment, the secret is only active once confirmed
+func (h *UserHandler) EnrollTOTP(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	enrollment, err := h.twoFactorService.BeginEnrollment(c.Context(), principal.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTwoFactorAlreadyEnabled):
+			return response.Error(c, fiber.StatusConflict, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, enrollment)
+}
+
+// ConfirmTOTP turns 2FA on and returns the recovery codes, they are never shown again
+func (h *UserHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	req := c.Locals("payload").(*dto.TwoFactorCodeRequest)
+	codes, err := h.twoFactorService.ConfirmEnrollment(c.Context(), principal.UserID, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidTwoFactorCode):
+			return response.Error(c, fiber.StatusUnauthorized, err.Error())
+		case errors.Is(err, service.ErrTwoFactorNotEnrolled):
+			return response.Error(c, fiber.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrTwoFactorAlreadyEnabled):
+			return response.Error(c, fiber.StatusConflict, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, fiber.Map{"recovery_codes": codes})
+}
+
+func (h *UserHandler) DisableTOTP(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	req := c.Locals("payload").(*dto.TwoFactorCodeRequest)
+	err := checkSecondFactor(c, h.lockoutService, h.userService, principal.UserID, func() error {
+		return h.twoFactorService.Disable(c.Context(), principal.UserID, req.Code)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidTwoFactorCode):
+			return response.Error(c, fiber.StatusUnauthorized, err.Error())
+		case errors.Is(err, service.ErrTwoFactorNotEnabled):
+			return response.Error(c, fiber.StatusBadRequest, err.Error())
+		}
+		return loginError(c, err)
+	}
+
+	return response.Success(c, nil)
+}
+
+// ListSessions lists the signed in browsers of the user
+func (h *UserHandler) ListSessions(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	infos, err := h.sessionRegistry.List(c.Context(), principal.UserID)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to list sessions")
+	}
+
+	return response.Success(c, dto.NewSessionResponses(infos, principal.SessionID))
+}
+
+// RevokeSession signs out one browser, :id is the ID returned by ListSessions
+func (h *UserHandler) RevokeSession(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	if err := h.sessionRegistry.Revoke(c.Context(), principal.UserID, c.Params("id")); err != nil {
+		if errors.Is(err, sessions.ErrSessionNotFound) {
+			return response.Error(c, fiber.StatusNotFound, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to revoke session")
+	}
+
+	return response.Success(c, nil)
+}
+
+// RevokeOtherSessions signs out every browser but the current one. Refresh tokens are revoked through /auth/token/revoke.
+func (h *UserHandler) RevokeOtherSessions(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	if err := h.sessionRegistry.RevokeAll(c.Context(), principal.UserID, principal.SessionID); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to revoke sessions")
+	}
+
+	return response.Success(c, nil)
+}
+
+// ListAccounts lists the sign in methods of the user: password, OAuth providers and passkeys
+func (h *UserHandler) ListAccounts(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	accounts, err := h.accountService.GetByUserID(c.Context(), principal.UserID)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, dto.NewAccountResponses(accounts))
+}
+
+// LinkAccount redirects to a provider to add it to the signed in user, /auth/callback/:provider finishes the link.
+// The flow is bound to this session, so the browser must be signed in with a session cookie.
+func (h *UserHandler) LinkAccount(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok || principal.Method != middleware.AuthMethodSession {
+		return response.Error(c, fiber.StatusUnauthorized, "A signed in session is required to link an account")
+	}
+
+	returnTo, err := resolveReturnTo(h.cfg, c.Query("return_to"))
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	redirectURL, pending, err := h.accountService.BeginLink(c.Params("provider"))
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	sess, err := c.Locals("store").(*session.Store).Get(c)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to retreive session from locals")
+	}
+
+	storePendingOAuthState(sess, pending, returnTo)
+	sess.Set("oauth_link_user_id", principal.UserID.String())
+
+	if err := sess.Save(); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to save session")
+	}
+
+	return c.Redirect(redirectURL)
+}
+
+// UnlinkAccount removes a sign in method, the last one can't be removed
+func (h *UserHandler) UnlinkAccount(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	accountID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, "Invalid account ID")
+	}
+
+	if err := h.accountService.Unlink(c.Context(), principal.UserID, accountID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrAccountNotFound):
+			return response.Error(c, fiber.StatusNotFound, err.Error())
+		case errors.Is(err, service.ErrLastSignInMethod):
+			return response.Error(c, fiber.StatusConflict, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, nil)
 }
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"backend/internal/users/handler/dto"
+	"backend/internal/users/service"
+	"backend/pkg/middleware"
+	"backend/pkg/models"
+	"backend/pkg/security"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// fakeLockout blocks the second factor once failures reach max
+type fakeLockout struct {
+	service.LockoutService
+	max      int
+	failures int
+}
+
+func (l *fakeLockout) CheckSecondFactor(ctx context.Context, userID uuid.UUID, ip string) error {
+	if l.failures >= l.max {
+		return &security.BlockedError{Err: security.ErrAccountLocked, RetryAfter: time.Minute}
+	}
+	return nil
+}
+
+func (l *fakeLockout) RecordSecondFactorFailure(ctx context.Context, user *models.User, ip string) error {
+	l.failures++
+	return nil
+}
+
+func (l *fakeLockout) RecordSecondFactorSuccess(ctx context.Context, userID uuid.UUID) error {
+	l.failures = 0
+	return nil
+}
+
+type fakeUsers struct{ service.UserService }
+
+func (fakeUsers) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	user := &models.User{}
+	user.ID = id
+	return user, nil
+}
+
+// fakeTwoFactor accepts only code
+type fakeTwoFactor struct {
+	service.TwoFactorService
+	code     string
+	disabled bool
+}
+
+func (f *fakeTwoFactor) IsEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	return f.code != "", nil
+}
+
+func (f *fakeTwoFactor) Disable(ctx context.Context, userID uuid.UUID, code string) error {
+	if code != f.code {
+		return service.ErrInvalidTwoFactorCode
+	}
+	f.disabled = true
+	return nil
+}
+
+// Disabling TOTP takes a code, guessing it must hit the same lockout as the login
+func TestDisableTOTPUsesTheSecondFactorLockout(t *testing.T) {
+	twoFactor := &fakeTwoFactor{code: "123456"}
+	h := &UserHandler{userService: fakeUsers{}, twoFactorService: twoFactor, lockoutService: &fakeLockout{max: 3}}
+
+	app := fiber.New()
+	app.Delete("/totp", func(c *fiber.Ctx) error {
+		middleware.SetPrincipal(c, &middleware.Principal{UserID: uuid.New(), Method: middleware.AuthMethodSession})
+		return c.Next()
+	}, middleware.ValidateRequest(new(dto.TwoFactorCodeRequest)), h.DisableTOTP)
+
+	disable := func(code string) int {
+		req := httptest.NewRequest(fiber.MethodDelete, "/totp", strings.NewReader(`{"code":"`+code+`"}`))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 3; i++ {
+		if status := disable("000000"); status != fiber.StatusUnauthorized {
+			t.Fatalf("wrong code %d status = %d, want %d", i+1, status, fiber.StatusUnauthorized)
+		}
+	}
+	if status := disable("123456"); status != fiber.StatusTooManyRequests {
+		t.Errorf("status after the lockout = %d, want %d", status, fiber.StatusTooManyRequests)
+	}
+	if twoFactor.disabled {
+		t.Error("TOTP was disabled during the lockout")
+	}
+}
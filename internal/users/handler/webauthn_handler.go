@@ -0,0 +1,291 @@
+package handler
+
+import (
+	"backend/internal/users/handler/dto"
+	"backend/internal/users/service"
+	"backend/pkg/middleware"
+	"backend/pkg/response"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+// WebAuthn ceremonies, a challenge is only accepted by the ceremony it was issued for
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	ceremonySecondFactor = "second_factor"
+	ceremonyDisableMFA   = "disable_second_factor"
+)
+
+// BeginPasskeyRegistration returns the options of navigator.credentials.create() for the signed in user
+func (h *AuthHandler) BeginPasskeyRegistration(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	creation, ceremony, err := h.passkeyService.BeginRegistration(c.Context(), principal.UserID)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err := storeWebAuthnCeremony(c, ceremonyRegistration, ceremony); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, creation)
+}
+
+// FinishPasskeyRegistration stores the credential created by the browser, the body is the PublicKeyCredential
+func (h *AuthHandler) FinishPasskeyRegistration(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	name := c.Query("name")
+	if len(name) > 100 {
+		return response.Error(c, fiber.StatusBadRequest, "Passkey name must be at most 100 characters")
+	}
+
+	ceremony, err := popWebAuthnCeremony(c, ceremonyRegistration)
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	account, err := h.passkeyService.FinishRegistration(c.Context(), principal.UserID, ceremony, name, c.Body())
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidPasskey):
+			return response.Error(c, fiber.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrPasskeyRegistered):
+			return response.Error(c, fiber.StatusConflict, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, dto.NewAccountResponse(account))
+}
+
+// BeginPasskeyLogin returns the options of navigator.credentials.get() for a passwordless login
+func (h *AuthHandler) BeginPasskeyLogin(c *fiber.Ctx) error {
+	assertion, ceremony, err := h.passkeyService.BeginLogin(c.Context())
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err := storeWebAuthnCeremony(c, ceremonyLogin, ceremony); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, assertion)
+}
+
+// FinishPasskeyLogin signs the user in from the assertion. The passkey was unlocked with user
+// verification, so no other factor is asked for. ?remember_me=true keeps the session open longer.
+func (h *AuthHandler) FinishPasskeyLogin(c *fiber.Ctx) error {
+	ceremony, err := popWebAuthnCeremony(c, ceremonyLogin)
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	user, err := h.passkeyService.FinishLogin(c.Context(), ceremony, c.Body())
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPasskey) || errors.Is(err, service.ErrPasskeyCloneWarned) {
+			log.Printf("Passkey login failed: %v", err)
+			return response.Error(c, fiber.StatusUnauthorized, "Invalid passkey")
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err := h.createSession(c, user, c.QueryBool("remember_me")); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, dto.NewUserResponse(user))
+}
+
+// BeginPasskeySecondFactor asks for a passkey of the user whose login is pending a second factor
+func (h *AuthHandler) BeginPasskeySecondFactor(c *fiber.Ctx) error {
+	sess, err := c.Locals("store").(*session.Store).Get(c)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to retreive session from locals")
+	}
+
+	userID, ok := pendingMFAUserID(sess)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "No pending two-factor login")
+	}
+
+	assertion, ceremony, err := h.passkeyService.BeginSecondFactor(c.Context(), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrNoPasskeys) {
+			return response.Error(c, fiber.StatusBadRequest, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err := storeWebAuthnCeremony(c, ceremonySecondFactor, ceremony); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, assertion)
+}
+
+// FinishPasskeySecondFactor completes the pending login with the passkey assertion
+func (h *AuthHandler) FinishPasskeySecondFactor(c *fiber.Ctx) error {
+	ceremony, err := popWebAuthnCeremony(c, ceremonySecondFactor)
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	sess, err := c.Locals("store").(*session.Store).Get(c)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, "Failed to retreive session from locals")
+	}
+
+	userID, ok := pendingMFAUserID(sess)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "No pending two-factor login")
+	}
+
+	if err := h.passkeyService.FinishSecondFactor(c.Context(), userID, ceremony, c.Body()); err != nil {
+		if errors.Is(err, service.ErrInvalidPasskey) || errors.Is(err, service.ErrPasskeyCloneWarned) {
+			log.Printf("Passkey second factor failed: %v", err)
+			return rejectMFAAttempt(c, sess, errors.New("Invalid passkey"))
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return h.completeMFA(c, sess, userID)
+}
+
+// EnablePasskeySecondFactor makes the password login of the signed in user ask for one of their passkeys
+func (h *AuthHandler) EnablePasskeySecondFactor(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	hasPasskeys, err := h.passkeyService.HasPasskeys(c.Context(), principal.UserID)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+	if !hasPasskeys {
+		return response.Error(c, fiber.StatusBadRequest, service.ErrNoPasskeys.Error())
+	}
+
+	return h.setPasskeySecondFactor(c, principal, true)
+}
+
+// BeginDisablePasskeySecondFactor asks the signed in user for a passkey, like disabling TOTP asks for a code
+func (h *AuthHandler) BeginDisablePasskeySecondFactor(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	assertion, ceremony, err := h.passkeyService.BeginSecondFactor(c.Context(), principal.UserID)
+	if err != nil {
+		if errors.Is(err, service.ErrNoPasskeys) {
+			return response.Error(c, fiber.StatusBadRequest, err.Error())
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err := storeWebAuthnCeremony(c, ceremonyDisableMFA, ceremony); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, assertion)
+}
+
+// FinishDisablePasskeySecondFactor turns the passkey second factor off once the assertion is verified
+func (h *AuthHandler) FinishDisablePasskeySecondFactor(c *fiber.Ctx) error {
+	principal, ok := middleware.GetPrincipal(c)
+	if !ok {
+		return response.Error(c, fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	ceremony, err := popWebAuthnCeremony(c, ceremonyDisableMFA)
+	if err != nil {
+		return response.Error(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := h.passkeyService.FinishSecondFactor(c.Context(), principal.UserID, ceremony, c.Body()); err != nil {
+		if errors.Is(err, service.ErrInvalidPasskey) || errors.Is(err, service.ErrPasskeyCloneWarned) {
+			log.Printf("Passkey check failed: %v", err)
+			return response.Error(c, fiber.StatusUnauthorized, "Invalid passkey")
+		}
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return h.setPasskeySecondFactor(c, principal, false)
+}
+
+func (h *AuthHandler) setPasskeySecondFactor(c *fiber.Ctx, principal *middleware.Principal, enabled bool) error {
+	user, err := h.userService.GetByID(c.Context(), principal.UserID)
+	if err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	user.PasskeySecondFactor = enabled
+	if err := h.userService.Update(c.Context(), user); err != nil {
+		return response.Error(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return response.Success(c, dto.NewUserResponse(user))
+}
+
+// storeWebAuthnCeremony keeps the challenge in the session until the browser answers it
+func storeWebAuthnCeremony(c *fiber.Ctx, name string, ceremony *webauthn.SessionData) error {
+	sess, err := c.Locals("store").(*session.Store).Get(c)
+	if err != nil {
+		return errors.New("Failed to retreive session from locals")
+	}
+
+	data, err := json.Marshal(ceremony)
+	if err != nil {
+		return err
+	}
+
+	sess.Set("webauthn_ceremony", name)
+	sess.Set("webauthn_session", string(data))
+
+	if err := sess.Save(); err != nil {
+		return errors.New("Failed to save session")
+	}
+	return nil
+}
+
+// popWebAuthnCeremony removes the pending challenge from the session so it can only be answered once
+func popWebAuthnCeremony(c *fiber.Ctx, name string) (*webauthn.SessionData, error) {
+	sess, err := c.Locals("store").(*session.Store).Get(c)
+	if err != nil {
+		return nil, errors.New("Failed to retreive session from locals")
+	}
+
+	stored, _ := sess.Get("webauthn_ceremony").(string)
+	data, _ := sess.Get("webauthn_session").(string)
+	sess.Delete("webauthn_ceremony")
+	sess.Delete("webauthn_session")
+
+	if err := sess.Save(); err != nil {
+		return nil, errors.New("Failed to save session")
+	}
+
+	if stored != name || data == "" {
+		return nil, errors.New("No pending passkey ceremony")
+	}
+
+	var ceremony webauthn.SessionData
+	if err := json.Unmarshal([]byte(data), &ceremony); err != nil {
+		return nil, errors.New("No pending passkey ceremony")
+	}
+	return &ceremony, nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AccountRepository interface {
@@ -14,6 +15,8 @@ type AccountRepository interface {
 	FindByProviderID(ctx context.Context, provider, providerAccountID string) (*models.Account, error)
 	Update(ctx context.Context, account *models.Account) error
 	Delete(ctx context.Context, id uuid.UUID) error
+	DeleteUnlessLast(ctx context.Context, userID, id uuid.UUID) (bool, error)
+	UpdateLocked(ctx context.Context, id uuid.UUID, update func(account *models.Account) (bool, error)) error
 }
 
 type accountRepository struct {
@@ -46,3 +49,51 @@ func (r *accountRepository) Update(ctx context.Context, account *models.Account)
 func (r *accountRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return r.db.WithContext(ctx).Delete(&models.Account{}, id).Error
 }
+
+// DeleteUnlessLast removes an account of a user, it returns false and keeps it when it is their last one.
+// The user row stays locked until the delete, so two concurrent calls can't both count the other account.
+func (r *accountRepository) DeleteUnlessLast(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	deleted := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.Account{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count <= 1 {
+			return nil
+		}
+
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Account{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
+// UpdateLocked reads an account under a row lock and saves it if update reports a change. The lock is held
+// until then, so concurrent calls on any instance see the account as the previous one left it.
+func (r *accountRepository) UpdateLocked(ctx context.Context, id uuid.UUID, update func(account *models.Account) (bool, error)) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var account models.Account
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, "id = ?", id).Error; err != nil {
+			return err
+		}
+
+		changed, err := update(&account)
+		if err != nil || !changed {
+			return err
+		}
+		return tx.Save(&account).Error
+	})
+}
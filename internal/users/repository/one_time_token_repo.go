@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"backend/pkg/models"
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type OneTimeTokenRepository interface {
+	Create(ctx context.Context, token *models.OneTimeToken) error
+	FindByHash(ctx context.Context, purpose, tokenHash string) (*models.OneTimeToken, error)
+	MarkUsed(ctx context.Context, id uuid.UUID) (bool, error)
+	InvalidateByUserID(ctx context.Context, userID uuid.UUID, purpose string) error
+	CountSince(ctx context.Context, userID uuid.UUID, purpose string, since time.Time) (int64, error)
+	CountByEmailSince(ctx context.Context, email, purpose string, since time.Time) (int64, error)
+}
+
+type oneTimeTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewOneTimeTokenRepository(db *gorm.DB) OneTimeTokenRepository {
+	return &oneTimeTokenRepository{db: db}
+}
+
+func (r *oneTimeTokenRepository) Create(ctx context.Context, token *models.OneTimeToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+func (r *oneTimeTokenRepository) FindByHash(ctx context.Context, purpose, tokenHash string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	err := r.db.WithContext(ctx).
+		Where("purpose = ? AND token_hash = ?", purpose, tokenHash).
+		First(&token).Error
+	return &token, err
+}
+
+// MarkUsed consumes a token, it returns false if another request already used it
+func (r *oneTimeTokenRepository) MarkUsed(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&models.OneTimeToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// InvalidateByUserID consumes every pending token of a user for the given purpose
+func (r *oneTimeTokenRepository) InvalidateByUserID(ctx context.Context, userID uuid.UUID, purpose string) error {
+	return r.db.WithContext(ctx).
+		Model(&models.OneTimeToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+// CountSince counts the tokens issued to a user for a purpose since the given time, used for throttling
+func (r *oneTimeTokenRepository) CountSince(ctx context.Context, userID uuid.UUID, purpose string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&models.OneTimeToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Count(&count).Error
+	return count, err
+}
+
+// CountByEmailSince counts the tokens sent to an address for a purpose since the given time, used for throttling
+func (r *oneTimeTokenRepository) CountByEmailSince(ctx context.Context, email, purpose string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&models.OneTimeToken{}).
+		Where("email = ? AND purpose = ? AND created_at > ?", email, purpose, since).
+		Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"backend/pkg/models"
+	"context"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SecurityEventRepository interface {
+	Create(ctx context.Context, event *models.SecurityEvent) error
+	FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.SecurityEvent, error)
+}
+
+type securityEventRepository struct {
+	db *gorm.DB
+}
+
+func NewSecurityEventRepository(db *gorm.DB) SecurityEventRepository {
+	return &securityEventRepository{db: db}
+}
+
+func (r *securityEventRepository) Create(ctx context.Context, event *models.SecurityEvent) error {
+	return r.db.WithContext(ctx).Create(event).Error
+}
+
+// FindByUserID returns the events of a user, most recent first
+func (r *securityEventRepository) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.SecurityEvent, error) {
+	var events []models.SecurityEvent
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&events).Error
+	return events, err
+}
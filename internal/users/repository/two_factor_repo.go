@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"backend/pkg/models"
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TwoFactorRepository interface {
+	FindByUserID(ctx context.Context, userID uuid.UUID) (*models.TwoFactor, error)
+	Save(ctx context.Context, twoFactor *models.TwoFactor) error
+	Enable(ctx context.Context, twoFactor *models.TwoFactor, codeHashes []string) error
+	Delete(ctx context.Context, userID uuid.UUID) error
+	AdvanceStep(ctx context.Context, id uuid.UUID, step int64) (bool, error)
+	FindUnusedRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (*models.RecoveryCode, error)
+	MarkRecoveryCodeUsed(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+type twoFactorRepository struct {
+	db *gorm.DB
+}
+
+func NewTwoFactorRepository(db *gorm.DB) TwoFactorRepository {
+	return &twoFactorRepository{db: db}
+}
+
+func (r *twoFactorRepository) FindByUserID(ctx context.Context, userID uuid.UUID) (*models.TwoFactor, error) {
+	var twoFactor models.TwoFactor
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&twoFactor).Error
+	return &twoFactor, err
+}
+
+// Save creates or replaces the authenticator of a user, a pending enrollment is overwritten
+func (r *twoFactorRepository) Save(ctx context.Context, twoFactor *models.TwoFactor) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", twoFactor.UserID).Delete(&models.TwoFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(twoFactor).Error
+	})
+}
+
+// Enable turns the authenticator on and replaces the recovery codes of the user
+func (r *twoFactorRepository) Enable(ctx context.Context, twoFactor *models.TwoFactor, codeHashes []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(twoFactor).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", twoFactor.UserID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]models.RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = models.RecoveryCode{UserID: twoFactor.UserID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// Delete removes the authenticator and the recovery codes of a user
+func (r *twoFactorRepository) Delete(ctx context.Context, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&models.TwoFactor{}).Error
+	})
+}
+
+// AdvanceStep records the time step of an accepted code, it returns false if that step or a later one was already used
+func (r *twoFactorRepository) AdvanceStep(ctx context.Context, id uuid.UUID, step int64) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&models.TwoFactor{}).
+		Where("id = ? AND last_used_step < ?", id, step).
+		Update("last_used_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *twoFactorRepository) FindUnusedRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (*models.RecoveryCode, error) {
+	var code models.RecoveryCode
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		First(&code).Error
+	return &code, err
+}
+
+// MarkRecoveryCodeUsed consumes a recovery code, it returns false if another request already used it
+func (r *twoFactorRepository) MarkRecoveryCodeUsed(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&models.RecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
@@ -3,33 +3,114 @@ package users
 import (
 	"backend/internal/users/handler"
 	"backend/internal/users/handler/dto"
+	"backend/internal/users/repository"
+	"backend/internal/users/service"
 	"backend/pkg/config"
+	"backend/pkg/mailer"
 	"backend/pkg/middleware"
+	"backend/pkg/oauth"
+	"backend/pkg/security"
+	"backend/pkg/sessions"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
 
-func RegisterUserRoutes(api fiber.Router, cfg *config.Config, db *gorm.DB) {
-	userHandler := handler.InitUserHandler(db)
+func RegisterUserRoutes(
+	api fiber.Router,
+	cfg *config.Config,
+	db *gorm.DB,
+	sessionRegistry *sessions.Registry,
+	storage fiber.Storage,
+	outbox *mailer.Outbox,
+	loginGuard *security.LoginGuard,
+	passwordPolicy *security.PasswordPolicy,
+	oauthProviders *oauth.Registry,
+) {
+	userHandler := handler.InitUserHandler(cfg, db, sessionRegistry, outbox, loginGuard, passwordPolicy, oauthProviders)
 
-	users := api.Group("/users")
+	users := api.Group("/users", middleware.RequireAuth())
+	if cfg.RequireVerifiedEmail {
+		userService := service.NewUserService(repository.NewUserRepository(db))
+		users.Use(middleware.RequireVerifiedEmail(userService.IsEmailVerified))
+	}
 	{
 		users.Get("/me", userHandler.GetMe)
 		users.Put("/me", middleware.ValidateRequest(new(dto.UpdateUserRequest)), userHandler.UpdateMe)
+		users.Put("/me/password", middleware.ValidateRequest(new(dto.ChangePasswordRequest)), middleware.LimitFailuresPerUser(storage, 5, 15*time.Minute), userHandler.ChangePassword)
+		users.Post("/me/2fa/totp", userHandler.EnrollTOTP)
+		users.Post("/me/2fa/totp/confirm", middleware.ValidateRequest(new(dto.TwoFactorCodeRequest)), userHandler.ConfirmTOTP)
+		users.Delete("/me/2fa/totp", middleware.ValidateRequest(new(dto.TwoFactorCodeRequest)), userHandler.DisableTOTP)
+		users.Get("/me/accounts", userHandler.ListAccounts)
+		users.Get("/me/accounts/link/:provider", userHandler.LinkAccount)
+		users.Delete("/me/accounts/:id", userHandler.UnlinkAccount)
+		users.Get("/me/sessions", userHandler.ListSessions)
+		users.Delete("/me/sessions", userHandler.RevokeOtherSessions)
+		users.Delete("/me/sessions/:id", userHandler.RevokeSession)
 	}
 }
 
-func RegisterAuthRoutes(api fiber.Router, cfg *config.Config, db *gorm.DB) {
-	authHandler := handler.InitAuthHandler(cfg, db)
+func RegisterAuthRoutes(
+	api fiber.Router,
+	cfg *config.Config,
+	db *gorm.DB,
+	sessionRegistry *sessions.Registry,
+	outbox *mailer.Outbox,
+	loginGuard *security.LoginGuard,
+	passwordPolicy *security.PasswordPolicy,
+	oauthProviders *oauth.Registry,
+) {
+	authHandler := handler.InitAuthHandler(cfg, db, sessionRegistry, outbox, loginGuard, passwordPolicy, oauthProviders)
 
 	auth := api.Group("/auth")
 	{
 		auth.Post("/register", middleware.ValidateRequest(new(dto.RegisterRequest)), authHandler.Register)
 		auth.Post("/login", middleware.ValidateRequest(new(dto.LoginRequest)), authHandler.Login)
+		auth.Post("/2fa/verify", middleware.ValidateRequest(new(dto.TwoFactorCodeRequest)), authHandler.VerifyTwoFactor)
 		auth.Get("/oauth/:provider", authHandler.OAuthSignIn)
 		auth.Get("/callback/:provider", authHandler.OAuthCallback)
 		auth.Post("/logout", authHandler.Logout)
 		auth.Get("/session", authHandler.CheckSession)
+		auth.Post("/verify-email", middleware.ValidateRequest(new(dto.VerifyEmailRequest)), authHandler.VerifyEmail)
+		auth.Post("/verify-email/resend", middleware.RequireAuth(), authHandler.ResendVerification)
+		auth.Post("/magic-link", middleware.ValidateRequest(new(dto.MagicLinkRequest)), authHandler.SendMagicLink)
+		auth.Post("/magic-link/consume", middleware.ValidateRequest(new(dto.ConsumeMagicLinkRequest)), authHandler.ConsumeMagicLink)
+		auth.Post("/password/forgot", middleware.ValidateRequest(new(dto.ForgotPasswordRequest)), authHandler.ForgotPassword)
+		auth.Post("/password/reset", middleware.ValidateRequest(new(dto.ResetPasswordRequest)), authHandler.ResetPassword)
+		auth.Post("/token", middleware.ValidateRequest(new(dto.TokenRequest)), authHandler.IssueToken)
+		auth.Post("/token/refresh", middleware.ValidateRequest(new(dto.RefreshTokenRequest)), authHandler.RefreshToken)
+		auth.Post("/token/revoke", middleware.ValidateRequest(new(dto.RefreshTokenRequest)), authHandler.RevokeToken)
+	}
+
+	// The finish endpoints take the PublicKeyCredential serialized by the browser as is
+	webAuthn := auth.Group("/webauthn")
+	{
+		webAuthn.Post("/register/begin", middleware.RequireAuth(), authHandler.BeginPasskeyRegistration)
+		webAuthn.Post("/register/finish", middleware.RequireAuth(), authHandler.FinishPasskeyRegistration)
+		webAuthn.Post("/login/begin", authHandler.BeginPasskeyLogin)
+		webAuthn.Post("/login/finish", authHandler.FinishPasskeyLogin)
+		webAuthn.Post("/2fa/begin", authHandler.BeginPasskeySecondFactor)
+		webAuthn.Post("/2fa/finish", authHandler.FinishPasskeySecondFactor)
+		webAuthn.Post("/2fa/enable", middleware.RequireAuth(), authHandler.EnablePasskeySecondFactor)
+		webAuthn.Post("/2fa/disable/begin", middleware.RequireAuth(), authHandler.BeginDisablePasskeySecondFactor)
+		webAuthn.Post("/2fa/disable/finish", middleware.RequireAuth(), authHandler.FinishDisablePasskeySecondFactor)
+	}
+}
+
+func RegisterAdminRoutes(
+	api fiber.Router,
+	cfg *config.Config,
+	db *gorm.DB,
+	sessionRegistry *sessions.Registry,
+	loginGuard *security.LoginGuard,
+) {
+	adminHandler := handler.InitAdminHandler(cfg, db, sessionRegistry, loginGuard)
+
+	admin := api.Group("/admin", middleware.RequireAuth(), middleware.RequireRole("admin"))
+	{
+		admin.Post("/users/:id/unlock", adminHandler.UnlockUser)
+		admin.Get("/users/:id/security-events", adminHandler.GetSecurityEvents)
+		admin.Delete("/users/:id/sessions", adminHandler.RevokeUserSessions)
 	}
 }
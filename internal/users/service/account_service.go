@@ -2,10 +2,25 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"backend/internal/users/repository"
 	"backend/pkg/models"
+	"backend/pkg/oauth"
+	"backend/pkg/utils"
 
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAccountNotFound        = errors.New("account not found")
+	ErrAccountLinkedElsewhere = errors.New("this provider account is linked to another user")
+	ErrLastSignInMethod       = errors.New("this is the last way to sign in, add another one before removing it")
+	ErrProviderNotLinked      = errors.New("this provider is not linked to the user")
+	ErrProviderReauthRequired = errors.New("the provider token can't be refreshed, sign in with the provider again")
 )
 
 type AccountService interface {
@@ -14,14 +29,28 @@ type AccountService interface {
 	GetByProviderID(ctx context.Context, provider, providerAccountID string) (*models.Account, error)
 	Update(ctx context.Context, account *models.Account) error
 	Delete(ctx context.Context, id uuid.UUID) error
+	BeginLink(provider string) (string, *OAuthState, error)
+	LinkOAuthAccount(ctx context.Context, userID uuid.UUID, provider, code, state string, pending *OAuthState) (*models.Account, error)
+	Unlink(ctx context.Context, userID, accountID uuid.UUID) error
+	ProviderToken(ctx context.Context, userID uuid.UUID, provider string) (*oauth2.Token, error)
 }
 
 type accountService struct {
 	accountRepo repository.AccountRepository
+	userRepo    repository.UserRepository
+	oauth       oauthFlow
 }
 
-func NewAccountService(accountRepo repository.AccountRepository) AccountService {
-	return &accountService{accountRepo: accountRepo}
+func NewAccountService(
+	accountRepo repository.AccountRepository,
+	userRepo repository.UserRepository,
+	providers *oauth.Registry,
+) AccountService {
+	return &accountService{
+		accountRepo: accountRepo,
+		userRepo:    userRepo,
+		oauth:       newOAuthFlow(providers),
+	}
 }
 
 func (s *accountService) Create(ctx context.Context, account *models.Account) error {
@@ -43,3 +72,149 @@ func (s *accountService) Update(ctx context.Context, account *models.Account) er
 func (s *accountService) Delete(ctx context.Context, id uuid.UUID) error {
 	return s.accountRepo.Delete(ctx, id)
 }
+
+// BeginLink starts the OAuth flow that adds a provider to a signed in user
+func (s *accountService) BeginLink(provider string) (string, *OAuthState, error) {
+	return s.oauth.redirectURL(provider)
+}
+
+// LinkOAuthAccount completes BeginLink. The identity is attached to userID whatever its email,
+// the user proved they own it by signing in to the provider.
+func (s *accountService) LinkOAuthAccount(
+	ctx context.Context,
+	userID uuid.UUID,
+	provider, code, state string,
+	pending *OAuthState,
+) (*models.Account, error) {
+	userInfo, err := s.oauth.userInfo(ctx, provider, code, state, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.linkIdentity(ctx, userID, provider, userInfo)
+}
+
+// linkIdentity creates the account of a provider identity for a user and fills the empty fields of their profile
+func (s *accountService) linkIdentity(ctx context.Context, userID uuid.UUID, provider string, userInfo *utils.UserInfo) (*models.Account, error) {
+	existing, err := s.accountRepo.FindByProviderID(ctx, provider, fmt.Sprint(userInfo.ID))
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, ErrAccountLinkedElsewhere
+		}
+		return existing, nil
+	}
+
+	account := newOAuthAccount(userID, provider, userInfo)
+	if err := s.accountRepo.Create(ctx, account); err != nil {
+		return nil, fmt.Errorf("failed to create OAuth account: %w", err)
+	}
+
+	// The account is linked either way, a stale profile is not worth failing for
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load user %s to update their profile: %v", userID, err)
+		return account, nil
+	}
+	if applyProviderProfile(user, userInfo) {
+		if err := s.userRepo.Update(ctx, user); err != nil {
+			log.Printf("Failed to update the profile of user %s: %v", userID, err)
+		}
+	}
+
+	return account, nil
+}
+
+// Unlink removes a sign in method of a user, as long as another one remains
+func (s *accountService) Unlink(ctx context.Context, userID, accountID uuid.UUID) error {
+	accounts, err := s.accountRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, account := range accounts {
+		if account.ID == accountID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrAccountNotFound
+	}
+
+	// Counted again in the delete, another unlink may be removing the other account right now
+	deleted, err := s.accountRepo.DeleteUnlessLast(ctx, userID, accountID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAccountNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrLastSignInMethod
+	}
+	return nil
+}
+
+// ProviderToken returns a valid token of a provider linked to the user, for calling the provider API on their behalf.
+// An expired token is refreshed and stored again. ErrProviderReauthRequired means the user must sign in
+// with the provider again, the provider issued no refresh token or revoked it.
+func (s *accountService) ProviderToken(ctx context.Context, userID uuid.UUID, provider string) (*oauth2.Token, error) {
+	account, err := s.providerAccount(ctx, userID, provider)
+	if err != nil {
+		return nil, err
+	}
+	if token := providerToken(account); token.Valid() {
+		return token, nil
+	}
+
+	// The refresh runs under a lock of the account row, providers that rotate refresh tokens revoke
+	// the one stored by the loser of a race between two requests
+	var refreshed *oauth2.Token
+	err = s.accountRepo.UpdateLocked(ctx, account.ID, func(account *models.Account) (bool, error) {
+		// Another request may have refreshed the token while this one waited
+		token := providerToken(account)
+		if token.Valid() {
+			refreshed = token
+			return false, nil
+		}
+		if token.RefreshToken == "" {
+			return false, ErrProviderReauthRequired
+		}
+
+		source, err := s.oauth.tokenSource(ctx, provider, token)
+		if err != nil {
+			return false, err
+		}
+		if refreshed, err = source.Token(); err != nil {
+			var retrieveErr *oauth2.RetrieveError
+			if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+				return false, ErrProviderReauthRequired
+			}
+			return false, fmt.Errorf("failed to refresh the %s token: %w", provider, err)
+		}
+
+		setProviderToken(account, refreshed)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refreshed, nil
+}
+
+// providerAccount returns the account a user linked with provider
+func (s *accountService) providerAccount(ctx context.Context, userID uuid.UUID, provider string) (*models.Account, error) {
+	accounts, err := s.accountRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range accounts {
+		if accounts[i].Type == models.AccountTypeOAuth && accounts[i].Provider == provider {
+			return &accounts[i], nil
+		}
+	}
+	return nil, ErrProviderNotLinked
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"backend/pkg/config"
+	"backend/pkg/models"
+	"backend/pkg/oauth"
+
+	"github.com/google/uuid"
+)
+
+// Every handler builds its own accountService, the refreshes of an account must still be serialized
+// or a provider rotating refresh tokens revokes the one stored by the loser of the race
+func TestProviderTokenRefreshesOncePerAccount(t *testing.T) {
+	var refreshes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := refreshes.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access-%d","refresh_token":"refresh-%d","token_type":"bearer","expires_in":3600}`, n, n)
+	}))
+	defer server.Close()
+
+	providers := oauth.LoadRegistry(config.OAuthProviders{
+		"gitlab": {
+			Provider:     "gitlab",
+			ClientID:     "client-id",
+			ClientSecret: "client-secret",
+			Settings:     map[string]string{"BASE_URL": server.URL},
+		},
+	}, nil)
+
+	userID := uuid.New()
+	accountRepo := newFakeAccountRepo(&models.Account{
+		UserID:            userID,
+		Type:              models.AccountTypeOAuth,
+		Provider:          "gitlab",
+		ProviderAccountID: "42",
+		AccessToken:       "expired",
+		RefreshToken:      "refresh-0",
+		ExpiresAt:         time.Now().Add(-time.Hour),
+	})
+	services := []AccountService{
+		NewAccountService(accountRepo, newFakeUserRepo(), providers),
+		NewAccountService(accountRepo, newFakeUserRepo(), providers),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(service AccountService) {
+			defer wg.Done()
+			token, err := service.ProviderToken(context.Background(), userID, "gitlab")
+			if err != nil {
+				t.Errorf("ProviderToken() error = %v", err)
+				return
+			}
+			if token.AccessToken != "access-1" {
+				t.Errorf("AccessToken = %q, want access-1", token.AccessToken)
+			}
+		}(services[i%len(services)])
+	}
+	wg.Wait()
+
+	if got := refreshes.Load(); got != 1 {
+		t.Errorf("the token was refreshed %d times, want once", got)
+	}
+}
+
+func TestUnlinkKeepsTheLastSignInMethod(t *testing.T) {
+	userID := uuid.New()
+	password := &models.Account{UserID: userID, Type: models.AccountTypeCredentials, Provider: "credentials", ProviderAccountID: "jane@example.com"}
+	github := &models.Account{UserID: userID, Type: models.AccountTypeOAuth, Provider: "github", ProviderAccountID: "42"}
+	accountRepo := newFakeAccountRepo(password, github)
+	service := NewAccountService(accountRepo, newFakeUserRepo(), oauth.NewRegistry())
+
+	if err := service.Unlink(context.Background(), userID, github.ID); err != nil {
+		t.Fatalf("Unlink() error = %v", err)
+	}
+	if err := service.Unlink(context.Background(), userID, password.ID); !errors.Is(err, ErrLastSignInMethod) {
+		t.Errorf("Unlink() of the last account error = %v, want ErrLastSignInMethod", err)
+	}
+	if err := service.Unlink(context.Background(), uuid.New(), password.ID); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Unlink() of another user's account error = %v, want ErrAccountNotFound", err)
+	}
+}
@@ -4,129 +4,170 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"log"
+	"sync"
+	"time"
 	"backend/internal/users/repository"
-	"backend/pkg/config"
 	"backend/pkg/models"
+	"backend/pkg/oauth"
 	"backend/pkg/utils"
 
-	"golang.org/x/oauth2"
+	"github.com/google/uuid"
 )
 
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserNotFound       = errors.New("user not found")
+
+	ErrOAuthCodeMissing      = errors.New("authorization code is missing")
+	ErrOAuthStateMissing     = errors.New("state parameter is missing")
+	ErrOAuthStateNotFound    = errors.New("no pending OAuth login for this session")
+	ErrOAuthStateExpired     = errors.New("OAuth state has expired")
+	ErrOAuthStateMismatch    = errors.New("OAuth state does not match")
+	ErrOAuthProviderMismatch = errors.New("OAuth provider does not match the pending login")
+	ErrOAuthEmailInUse       = errors.New("a user already has this email, sign in and link the provider from your account")
+)
+
+// Policies of OAuthEmailLinking, how an OAuth sign in is joined to the existing user with the same email
+const (
+	EmailLinkingVerified = "verified" // When the provider vouches for the email and the user verified it too
+	EmailLinkingNever    = "never"    // Never, the user links the provider while signed in
 )
 
+// OAuthStateTTL is how long a pending OAuth login stays valid after the redirect
+const OAuthStateTTL = 10 * time.Minute
+
+// OAuthState is the pending login kept server-side between the redirect and the callback
+type OAuthState struct {
+	Provider     string
+	State        string
+	CodeVerifier string // PKCE verifier, empty when the provider has PKCE disabled
+	Nonce        string // OpenID Connect nonce, empty for providers without ID token
+	ExpiresAt    time.Time
+}
+
 type AuthService interface {
 	Register(ctx context.Context, user *models.User, password string) error
-	Login(ctx context.Context, email, password string) error
-	GetOAuthRedirectURL(provider string) (string, error)
-	HandleOAuthCallback(ctx context.Context, provider, code, state string) (*models.User, error)
+	Login(ctx context.Context, email, password, ip string) (*models.User, error)
+	GetOAuthRedirectURL(provider string) (string, *OAuthState, error)
+	VerifyOAuthState(pending *OAuthState, provider, state string) error
+	HandleOAuthCallback(ctx context.Context, provider, code, state string, pending *OAuthState) (*models.User, error)
 }
 
 type authService struct {
 	userRepo       repository.UserRepository
 	accountRepo    repository.AccountRepository
-	oauthProviders *config.OAuthProviders
+	oauth          oauthFlow
+	emailLinking   string
+	lockoutService LockoutService
+	hasher         *utils.PasswordHasher
+	dummyHash      func() string
 }
 
 func NewAuthService(
 	userRepo repository.UserRepository,
 	accountRepo repository.AccountRepository,
+	lockoutService LockoutService,
+	hasher *utils.PasswordHasher,
+	emailLinking string,
+	providers *oauth.Registry,
 ) AuthService {
 	return &authService{
 		userRepo:       userRepo,
 		accountRepo:    accountRepo,
-		oauthProviders: config.LoadOAuthConfig(),
+		oauth:          newOAuthFlow(providers),
+		emailLinking:   emailLinking,
+		lockoutService: lockoutService,
+		hasher:         hasher,
+		// Checked when the email matches no password, so that unknown emails
+		// take as long as wrong passwords and can't be told apart
+		dummyHash: sync.OnceValue(func() string {
+			hash, _ := hasher.Hash(utils.GenerateRandomState())
+			return hash
+		}),
 	}
 }
 
 func (s *authService) Register(ctx context.Context, user *models.User, password string) error {
-	hashedPassword, err := utils.HashPassword(password)
+	hashedPassword, err := s.hasher.Hash(password)
 	if err != nil {
 		return err
 	}
 	account := &models.Account{
-		Type:     "credentials",
+		Type:     models.AccountTypeCredentials,
 		Password: hashedPassword,
 	}
 	user.Accounts = []models.Account{*account}
 	return s.userRepo.Create(ctx, user)
 }
 
-func (s *authService) Login(ctx context.Context, email, password string) error {
+// Login checks a password login coming from ip. Unknown emails and wrong passwords both return
+// ErrInvalidCredentials, a *security.BlockedError is returned while the account or the IP has to wait.
+func (s *authService) Login(ctx context.Context, email, password, ip string) (*models.User, error) {
+	if err := s.lockoutService.Check(ctx, email, ip); err != nil {
+		return nil, err
+	}
+
+	var account *models.Account
 	user, err := s.userRepo.FindByEmail(ctx, email)
 	if err != nil {
-		return ErrUserNotFound
+		user = nil
+	} else {
+		account, _ = credentialsAccount(user)
 	}
 
-	// Find credentials account
-	var credAccount models.Account
-	for _, acc := range user.Accounts {
-		if acc.Type == "credentials" {
-			credAccount = acc
-			break
+	// Always compare a hash, the result only counts when the user has a password
+	hash := s.dummyHash()
+	if account != nil {
+		hash = account.Password
+	}
+	match, needsRehash := s.hasher.Verify(password, hash)
+	if !match || account == nil {
+		if err := s.lockoutService.RecordFailure(ctx, user, email, ip); err != nil {
+			return nil, err
 		}
+		return nil, ErrInvalidCredentials
 	}
 
-	// Verify password
-	if !utils.CheckPassword(password, credAccount.Password) {
-		return ErrInvalidCredentials
+	if err := s.lockoutService.RecordSuccess(ctx, email); err != nil {
+		return nil, err
 	}
 
-	return nil
-}
-
-func (s *authService) GetOAuthRedirectURL(provider string) (string, error) {
-	var config oauth2.Config
-	switch provider {
-	case "google":
-		config = s.oauthProviders.Google.ToOAuth2Config()
-	case "discord":
-		config = s.oauthProviders.Discord.ToOAuth2Config()
-	default:
-		return "", fmt.Errorf("unsupported provider: %s", provider)
+	// The password is only known here, so this is when an outdated hash can be upgraded
+	if needsRehash {
+		s.rehash(ctx, account, password)
 	}
 
-	state := utils.GenerateRandomState()
-	return config.AuthCodeURL(state), nil
+	return user, nil
 }
 
-func (s *authService) HandleOAuthCallback(ctx context.Context, provider, code, state string) (*models.User, error) {
-	if code == "" {
-		return nil, fmt.Errorf("authorization code is missing")
-	}
-
-	if state == "" {
-		return nil, fmt.Errorf("state parameter is missing")
+// rehash replaces a hash made with an old algorithm or old parameters, a failure doesn't fail the login
+func (s *authService) rehash(ctx context.Context, account *models.Account, password string) {
+	hash, err := s.hasher.Hash(password)
+	if err == nil {
+		account.Password = hash
+		err = s.accountRepo.Update(ctx, account)
 	}
-
-	// Exchange code for token
-	token, err := s.exchangeCodeForToken(ctx, provider, code)
 	if err != nil {
-		return nil, fmt.Errorf("failed to exchange code: %w", err)
+		log.Printf("Failed to upgrade the password hash of account %s: %v", account.ID, err)
 	}
+}
 
-	// Get user info from provider
-	userInfo, err := s.getUserInfo(provider, token.AccessToken)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user info: %w", err)
-	}
+func (s *authService) GetOAuthRedirectURL(provider string) (string, *OAuthState, error) {
+	return s.oauth.redirectURL(provider)
+}
 
-	// Add token info to userInfo
-	userInfo.AccessToken = token.AccessToken
-	userInfo.TokenType = token.Type() // Use Type() method instead of direct access
+// VerifyOAuthState checks the state returned by the provider against the pending login.
+// The caller is responsible for discarding the pending login so it can only be used once.
+func (s *authService) VerifyOAuthState(pending *OAuthState, provider, state string) error {
+	return s.oauth.verifyState(pending, provider, state)
+}
 
-	// Get scopes from oauth config
-	var scopes []string
-	switch provider {
-	case "google":
-		scopes = s.oauthProviders.Google.Scopes
-	case "discord":
-		scopes = s.oauthProviders.Discord.Scopes
+func (s *authService) HandleOAuthCallback(ctx context.Context, provider, code, state string, pending *OAuthState) (*models.User, error) {
+	userInfo, err := s.oauth.userInfo(ctx, provider, code, state, pending)
+	if err != nil {
+		return nil, err
 	}
-	userInfo.Scope = strings.Join(scopes, " ")
 
 	// Find or create user
 	user, err := s.findOrCreateUser(ctx, userInfo, provider)
@@ -137,65 +178,45 @@ func (s *authService) HandleOAuthCallback(ctx context.Context, provider, code, s
 	return user, nil
 }
 
-func (s *authService) exchangeCodeForToken(ctx context.Context, provider, code string) (*oauth2.Token, error) {
-	var config oauth2.Config
-	switch provider {
-	case "google":
-		config = s.oauthProviders.Google.ToOAuth2Config()
-	case "discord":
-		config = s.oauthProviders.Discord.ToOAuth2Config()
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
-	}
-
-	token, err := config.Exchange(ctx, code)
-	if err != nil {
-		return nil, fmt.Errorf("failed to exchange token: %v", err)
-	}
-
-	return token, nil
-}
-
-func (s *authService) getUserInfo(provider, accessToken string) (*utils.UserInfo, error) {
-	switch provider {
-	case "google":
-		return utils.GetUserInfoFromGoogle(accessToken)
-	case "discord":
-		return utils.GetUserInfoFromDiscord(accessToken)
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
-	}
-}
-
 func (s *authService) findOrCreateUser(ctx context.Context, userInfo *utils.UserInfo, provider string) (*models.User, error) {
 	existingAccount, err := s.accountRepo.FindByProviderID(ctx, provider, fmt.Sprint(userInfo.ID))
 	if err == nil {
-		return s.userRepo.FindByID(ctx, existingAccount.UserID)
+		user, err := s.userRepo.FindByID(ctx, existingAccount.UserID)
+		if err != nil {
+			return nil, err
+		}
+		s.updateProviderToken(ctx, existingAccount, userInfo)
+		if applyProviderProfile(user, userInfo) {
+			if err := s.userRepo.Update(ctx, user); err != nil {
+				return nil, fmt.Errorf("failed to update user: %w", err)
+			}
+		}
+		return user, nil
 	}
 
 	// Try to find user by email
 	existingUser, err := s.userRepo.FindByEmail(ctx, userInfo.Email)
 	if err == nil {
-		// User exists, update fields
-		existingUser.Name = userInfo.Name
-		existingUser.Image = userInfo.Image
+		if s.emailLinking != EmailLinkingVerified {
+			return nil, ErrOAuthEmailInUse
+		}
 
-		if err := s.userRepo.Update(ctx, existingUser); err != nil {
-			return nil, fmt.Errorf("failed to update user: %w", err)
+		// Joining on an email the provider doesn't vouch for would hand the user to whoever typed it in.
+		// Joining a user who never proved the email is just as unsafe the other way round: whoever
+		// registered it first, e.g. with a password, would keep access to the merged user.
+		// In both cases the owner signs in with a method they already have and links the provider from there.
+		if !userInfo.EmailVerified || !existingUser.IsEmailVerified() {
+			return nil, ErrOAuthEmailInUse
 		}
 
-		// Create new OAuth account
-		account := &models.Account{
-			UserID:            existingUser.ID,
-			Type:              "oauth",
-			Provider:          provider,
-			ProviderAccountID: fmt.Sprint(userInfo.ID),
-			AccessToken:       userInfo.AccessToken,
-			TokenType:         userInfo.TokenType,
-			Scope:             userInfo.Scope,
+		if applyProviderProfile(existingUser, userInfo) {
+			if err := s.userRepo.Update(ctx, existingUser); err != nil {
+				return nil, fmt.Errorf("failed to update user: %w", err)
+			}
 		}
 
-		if err := s.accountRepo.Create(ctx, account); err != nil {
+		// Create new OAuth account
+		if err := s.accountRepo.Create(ctx, newOAuthAccount(existingUser.ID, provider, userInfo)); err != nil {
 			return nil, fmt.Errorf("failed to create OAuth account: %w", err)
 		}
 
@@ -209,6 +230,10 @@ func (s *authService) findOrCreateUser(ctx context.Context, userInfo *utils.User
 		Image: userInfo.Image,
 		Role:  "user",
 	}
+	if userInfo.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
 
 	// Create user first
 	if err := s.userRepo.Create(ctx, user); err != nil {
@@ -216,17 +241,7 @@ func (s *authService) findOrCreateUser(ctx context.Context, userInfo *utils.User
 	}
 
 	// Create OAuth account
-	account := &models.Account{
-		UserID:            user.ID,
-		Type:              "oauth",
-		Provider:          provider,
-		ProviderAccountID: fmt.Sprint(userInfo.ID),
-		AccessToken:       userInfo.AccessToken,
-		TokenType:         userInfo.TokenType,
-		Scope:             userInfo.Scope,
-	}
-
-	if err := s.accountRepo.Create(ctx, account); err != nil {
+	if err := s.accountRepo.Create(ctx, newOAuthAccount(user.ID, provider, userInfo)); err != nil {
 		// Rollback user creation on error
 		_ = s.userRepo.Delete(ctx, user.ID)
 		return nil, fmt.Errorf("failed to create OAuth account: %w", err)
@@ -234,3 +249,41 @@ func (s *authService) findOrCreateUser(ctx context.Context, userInfo *utils.User
 
 	return user, nil
 }
+
+// applyProviderProfile copies the provider profile to a user, only into empty fields unless the
+// user opted in to SyncProfile. It reports whether the user changed.
+func applyProviderProfile(user *models.User, userInfo *utils.UserInfo) bool {
+	changed := false
+	if userInfo.Name != "" && user.Name != userInfo.Name && (user.SyncProfile || user.Name == "") {
+		user.Name = userInfo.Name
+		changed = true
+	}
+	if userInfo.Image != "" && user.Image != userInfo.Image && (user.SyncProfile || user.Image == "") {
+		user.Image = userInfo.Image
+		changed = true
+	}
+	return changed
+}
+
+// updateProviderToken replaces the provider token of an account with the one of a new sign in.
+// The sign in goes on when it fails, the account keeps its previous token.
+func (s *authService) updateProviderToken(ctx context.Context, account *models.Account, userInfo *utils.UserInfo) {
+	setProviderToken(account, userInfo.OAuth2Token())
+	account.Scope = userInfo.Scope
+	if err := s.accountRepo.Update(ctx, account); err != nil {
+		log.Printf("Failed to store the %s token of account %s: %v", account.Provider, account.ID, err)
+	}
+}
+
+// newOAuthAccount builds the account of a provider identity
+func newOAuthAccount(userID uuid.UUID, provider string, userInfo *utils.UserInfo) *models.Account {
+	account := &models.Account{
+		UserID:            userID,
+		Type:              models.AccountTypeOAuth,
+		Provider:          provider,
+		ProviderAccountID: fmt.Sprint(userInfo.ID),
+		Scope:             userInfo.Scope,
+	}
+	setProviderToken(account, userInfo.OAuth2Token())
+	return account
+}
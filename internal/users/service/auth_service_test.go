@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/pkg/models"
+	"backend/pkg/utils"
+)
+
+func newTestAuthService(userRepo *fakeUserRepo, accountRepo *fakeAccountRepo) *authService {
+	return &authService{
+		userRepo:     userRepo,
+		accountRepo:  accountRepo,
+		emailLinking: EmailLinkingVerified,
+	}
+}
+
+func providerUserInfo(emailVerified bool) *utils.UserInfo {
+	return &utils.UserInfo{
+		ID:            "provider-id",
+		Name:          "Provider Name",
+		Email:         "jane@example.com",
+		Image:         "https://provider.example.com/avatar.png",
+		EmailVerified: emailVerified,
+		AccessToken:   "provider-access-token",
+		RefreshToken:  "provider-refresh-token",
+		Expiry:        time.Now().Add(time.Hour),
+	}
+}
+
+// An OAuth sign in only joins an existing user when both sides proved the email,
+// otherwise the owner has to sign in and link the provider
+func TestFindOrCreateUserJoinsOnlyVerifiedEmails(t *testing.T) {
+	verifiedAt := time.Now()
+	tests := []struct {
+		name             string
+		providerVerified bool
+		userVerifiedAt   *time.Time
+		wantErr          error
+	}{
+		{"provider email unverified", false, &verifiedAt, ErrOAuthEmailInUse},
+		{"user email unverified", true, nil, ErrOAuthEmailInUse},
+		{"both verified", true, &verifiedAt, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{Email: "jane@example.com", EmailVerifiedAt: tt.userVerifiedAt}
+			accountRepo := newFakeAccountRepo()
+			service := newTestAuthService(newFakeUserRepo(user), accountRepo)
+
+			got, err := service.findOrCreateUser(context.Background(), providerUserInfo(tt.providerVerified), "google")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("findOrCreateUser() error = %v, want %v", err, tt.wantErr)
+			}
+
+			accounts, _ := accountRepo.FindByUserID(context.Background(), user.ID)
+			if tt.wantErr != nil {
+				if len(accounts) != 0 {
+					t.Errorf("the provider was linked: %+v", accounts)
+				}
+				return
+			}
+			if got.ID != user.ID || len(accounts) != 1 {
+				t.Errorf("user = %v, accounts = %+v, want the existing user with the provider linked", got.ID, accounts)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"backend/internal/users/repository"
+	"backend/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fakeUserRepo keeps users in memory, the methods the tests don't need panic through the nil interface
+type fakeUserRepo struct {
+	repository.UserRepository
+	mu    sync.Mutex
+	users map[uuid.UUID]*models.User
+}
+
+func newFakeUserRepo(users ...*models.User) *fakeUserRepo {
+	r := &fakeUserRepo{users: make(map[uuid.UUID]*models.User)}
+	for _, user := range users {
+		if user.ID == uuid.Nil {
+			user.ID = uuid.New()
+		}
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+func (r *fakeUserRepo) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user.ID = uuid.New()
+	r.users[user.ID] = user
+	return nil
+}
+
+func (r *fakeUserRepo) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if user, ok := r.users[id]; ok {
+		copied := *user
+		return &copied, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUserRepo) Update(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *user
+	r.users[user.ID] = &copied
+	return nil
+}
+
+// fakeAccountRepo keeps accounts in memory
+type fakeAccountRepo struct {
+	repository.AccountRepository
+	mu       sync.Mutex
+	rowLock  sync.Mutex // Stands for the row locks of UpdateLocked
+	accounts map[uuid.UUID]*models.Account
+}
+
+func newFakeAccountRepo(accounts ...*models.Account) *fakeAccountRepo {
+	r := &fakeAccountRepo{accounts: make(map[uuid.UUID]*models.Account)}
+	for _, account := range accounts {
+		if account.ID == uuid.Nil {
+			account.ID = uuid.New()
+		}
+		r.accounts[account.ID] = account
+	}
+	return r
+}
+
+func (r *fakeAccountRepo) Create(ctx context.Context, account *models.Account) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	account.ID = uuid.New()
+	copied := *account
+	r.accounts[account.ID] = &copied
+	return nil
+}
+
+func (r *fakeAccountRepo) FindByUserID(ctx context.Context, userID uuid.UUID) ([]models.Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var accounts []models.Account
+	for _, account := range r.accounts {
+		if account.UserID == userID {
+			accounts = append(accounts, *account)
+		}
+	}
+	return accounts, nil
+}
+
+func (r *fakeAccountRepo) FindByProviderID(ctx context.Context, provider, providerAccountID string) (*models.Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, account := range r.accounts {
+		if account.Provider == provider && account.ProviderAccountID == providerAccountID {
+			copied := *account
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeAccountRepo) Update(ctx context.Context, account *models.Account) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *account
+	r.accounts[account.ID] = &copied
+	return nil
+}
+
+func (r *fakeAccountRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.accounts, id)
+	return nil
+}
+
+func (r *fakeAccountRepo) UpdateLocked(ctx context.Context, id uuid.UUID, update func(account *models.Account) (bool, error)) error {
+	r.rowLock.Lock()
+	defer r.rowLock.Unlock()
+
+	r.mu.Lock()
+	stored, ok := r.accounts[id]
+	r.mu.Unlock()
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+
+	account := *stored
+	changed, err := update(&account)
+	if err != nil || !changed {
+		return err
+	}
+	return r.Update(ctx, &account)
+}
+
+// fakeTwoFactorRepo keeps the 2FA settings in memory
+type fakeTwoFactorRepo struct {
+	repository.TwoFactorRepository
+	mu         sync.Mutex
+	twoFactors map[uuid.UUID]*models.TwoFactor
+}
+
+func newFakeTwoFactorRepo() *fakeTwoFactorRepo {
+	return &fakeTwoFactorRepo{twoFactors: make(map[uuid.UUID]*models.TwoFactor)}
+}
+
+func (r *fakeTwoFactorRepo) FindByUserID(ctx context.Context, userID uuid.UUID) (*models.TwoFactor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if twoFactor, ok := r.twoFactors[userID]; ok {
+		copied := *twoFactor
+		return &copied, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeTwoFactorRepo) Save(ctx context.Context, twoFactor *models.TwoFactor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *twoFactor
+	r.twoFactors[twoFactor.UserID] = &copied
+	return nil
+}
+
+func (r *fakeAccountRepo) DeleteUnlessLast(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, account := range r.accounts {
+		if account.UserID == userID {
+			count++
+		}
+	}
+	if count <= 1 {
+		return false, nil
+	}
+	if account, ok := r.accounts[id]; !ok || account.UserID != userID {
+		return false, gorm.ErrRecordNotFound
+	}
+	delete(r.accounts, id)
+	return true, nil
+}
+
+// fakeOneTimeTokenRepo keeps the one-time tokens in memory
+type fakeOneTimeTokenRepo struct {
+	repository.OneTimeTokenRepository
+	mu     sync.Mutex
+	tokens []models.OneTimeToken
+}
+
+func (r *fakeOneTimeTokenRepo) Create(ctx context.Context, token *models.OneTimeToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens = append(r.tokens, *token)
+	return nil
+}
+
+func (r *fakeOneTimeTokenRepo) CountSince(ctx context.Context, userID uuid.UUID, purpose string, since time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, token := range r.tokens {
+		if token.UserID != nil && *token.UserID == userID && token.Purpose == purpose && !token.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeOneTimeTokenRepo) CountByEmailSince(ctx context.Context, email, purpose string, since time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, token := range r.tokens {
+		if token.Email == email && token.Purpose == purpose && !token.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// blockingNotifier holds every message until release is closed, like a slow mail server
+type blockingNotifier struct {
+	Notifier
+	release chan struct{}
+	sent    chan string
+}
+
+func newBlockingNotifier() *blockingNotifier {
+	return &blockingNotifier{release: make(chan struct{}), sent: make(chan string, 1)}
+}
+
+func (n *blockingNotifier) SendPasswordReset(ctx context.Context, user *models.User, token string) error {
+	<-n.release
+	n.sent <- user.Email
+	return nil
+}
+
+func (n *blockingNotifier) SendMagicLink(ctx context.Context, user *models.User, token string) error {
+	<-n.release
+	n.sent <- user.Email
+	return nil
+}
+
+// fakeRefreshTokenRepo keeps the refresh tokens in memory, MarkUsed is conditional like the SQL update
+type fakeRefreshTokenRepo struct {
+	repository.RefreshTokenRepository
+	mu     sync.Mutex
+	tokens []*models.RefreshToken
+}
+
+func (r *fakeRefreshTokenRepo) Create(ctx context.Context, token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token.ID = uuid.New()
+	copied := *token
+	r.tokens = append(r.tokens, &copied)
+	return nil
+}
+
+func (r *fakeRefreshTokenRepo) FindByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRefreshTokenRepo) MarkUsed(ctx context.Context, id uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.ID == id && token.UsedAt == nil && token.RevokedAt == nil {
+			now := time.Now()
+			token.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeRefreshTokenRepo) RevokeFamily(ctx context.Context, familyID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
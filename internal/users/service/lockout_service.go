@@ -0,0 +1,147 @@
+package service
+
+import (
+	"backend/internal/users/repository"
+	"backend/pkg/models"
+	"backend/pkg/security"
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// LockoutService applies the failed login protection and records its lockouts as security events
+type LockoutService interface {
+	Check(ctx context.Context, email, ip string) error
+	RecordFailure(ctx context.Context, user *models.User, email, ip string) error
+	RecordSuccess(ctx context.Context, email string) error
+	CheckSecondFactor(ctx context.Context, userID uuid.UUID, ip string) error
+	RecordSecondFactorFailure(ctx context.Context, user *models.User, ip string) error
+	RecordSecondFactorSuccess(ctx context.Context, userID uuid.UUID) error
+	Unlock(ctx context.Context, user *models.User, reason string) error
+	UnlockSecondFactor(ctx context.Context, user *models.User, reason string) error
+	Events(ctx context.Context, userID uuid.UUID) ([]models.SecurityEvent, error)
+}
+
+type lockoutService struct {
+	guard     *security.LoginGuard
+	eventRepo repository.SecurityEventRepository
+}
+
+func NewLockoutService(guard *security.LoginGuard, eventRepo repository.SecurityEventRepository) LockoutService {
+	return &lockoutService{
+		guard:     guard,
+		eventRepo: eventRepo,
+	}
+}
+
+// Check returns a *security.BlockedError while the account or the IP has to wait
+func (s *lockoutService) Check(ctx context.Context, email, ip string) error {
+	return s.guard.Check(ctx, email, ip)
+}
+
+// RecordFailure counts a failed login, user is nil when the email matches no user
+func (s *lockoutService) RecordFailure(ctx context.Context, user *models.User, email, ip string) error {
+	lockout, err := s.guard.RecordFailure(ctx, email, ip)
+	if err != nil {
+		return fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	s.recordLockout(ctx, lockout, user, email, ip, "too many failed login attempts")
+	return nil
+}
+
+func (s *lockoutService) RecordSuccess(ctx context.Context, email string) error {
+	return s.guard.RecordSuccess(ctx, email)
+}
+
+// CheckSecondFactor returns a *security.BlockedError while the second factor of the user or the IP has to wait
+func (s *lockoutService) CheckSecondFactor(ctx context.Context, userID uuid.UUID, ip string) error {
+	return s.guard.CheckSecondFactor(ctx, userID.String(), ip)
+}
+
+// RecordSecondFactorFailure counts a wrong TOTP or recovery code of a user who passed the first factor
+func (s *lockoutService) RecordSecondFactorFailure(ctx context.Context, user *models.User, ip string) error {
+	lockout, err := s.guard.RecordSecondFactorFailure(ctx, user.ID.String(), ip)
+	if err != nil {
+		return fmt.Errorf("failed to record two-factor failure: %w", err)
+	}
+
+	s.recordLockout(ctx, lockout, user, user.Email, ip, "too many failed two-factor attempts")
+	return nil
+}
+
+func (s *lockoutService) RecordSecondFactorSuccess(ctx context.Context, userID uuid.UUID) error {
+	return s.guard.RecordSecondFactorSuccess(ctx, userID.String())
+}
+
+// Unlock lifts a lockout before it expires, reason tells who did it (e.g. "admin", "password_reset")
+func (s *lockoutService) Unlock(ctx context.Context, user *models.User, reason string) error {
+	if err := s.guard.Unlock(ctx, user.Email); err != nil {
+		return fmt.Errorf("failed to unlock account: %w", err)
+	}
+
+	s.record(ctx, &models.SecurityEvent{
+		UserID:  &user.ID,
+		Type:    models.SecurityEventAccountUnlocked,
+		Email:   user.Email,
+		Details: reason,
+	})
+
+	return nil
+}
+
+// UnlockSecondFactor lifts a second factor lockout, a password reset doesn't do it since it only proves the email
+func (s *lockoutService) UnlockSecondFactor(ctx context.Context, user *models.User, reason string) error {
+	if err := s.guard.UnlockSecondFactor(ctx, user.ID.String()); err != nil {
+		return fmt.Errorf("failed to unlock two-factor authentication: %w", err)
+	}
+
+	s.record(ctx, &models.SecurityEvent{
+		UserID:  &user.ID,
+		Type:    models.SecurityEventAccountUnlocked,
+		Email:   user.Email,
+		Details: reason + " (two-factor)",
+	})
+
+	return nil
+}
+
+func (s *lockoutService) Events(ctx context.Context, userID uuid.UUID) ([]models.SecurityEvent, error) {
+	return s.eventRepo.FindByUserID(ctx, userID)
+}
+
+// recordLockout stores an event for each lock a failure caused
+func (s *lockoutService) recordLockout(ctx context.Context, lockout security.Lockout, user *models.User, email, ip, details string) {
+	for _, eventType := range lockoutEventTypes(lockout) {
+		event := &models.SecurityEvent{
+			Type:    eventType,
+			Email:   email,
+			IP:      ip,
+			Details: details,
+		}
+		if user != nil {
+			event.UserID = &user.ID
+		}
+		s.record(ctx, event)
+	}
+}
+
+func lockoutEventTypes(lockout security.Lockout) []string {
+	var types []string
+	if lockout.Account {
+		types = append(types, models.SecurityEventAccountLocked)
+	}
+	if lockout.IP {
+		types = append(types, models.SecurityEventIPLocked)
+	}
+	return types
+}
+
+// record stores an event, a failure is logged rather than failing the login flow
+func (s *lockoutService) record(ctx context.Context, event *models.SecurityEvent) {
+	if err := s.eventRepo.Create(ctx, event); err != nil {
+		log.Printf("Failed to record security event %s for %s: %v", event.Type, event.Email, err)
+	}
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"backend/pkg/models"
+	"backend/pkg/security"
+	"slices"
+	"testing"
+)
+
+func TestLockoutEventTypes(t *testing.T) {
+	tests := []struct {
+		lockout security.Lockout
+		want    []string
+	}{
+		{security.Lockout{}, nil},
+		{security.Lockout{Account: true}, []string{models.SecurityEventAccountLocked}},
+		{security.Lockout{IP: true}, []string{models.SecurityEventIPLocked}},
+		{security.Lockout{Account: true, IP: true}, []string{models.SecurityEventAccountLocked, models.SecurityEventIPLocked}},
+	}
+	for _, tt := range tests {
+		if got := lockoutEventTypes(tt.lockout); !slices.Equal(got, tt.want) {
+			t.Errorf("lockoutEventTypes(%+v) = %v, want %v", tt.lockout, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"backend/internal/users/repository"
+	"backend/pkg/models"
+	"backend/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// MagicLinkTTL is how long a login link stays valid
+	MagicLinkTTL = 15 * time.Minute
+	// magicLinkCooldown is the minimum delay between two login links sent to an address
+	magicLinkCooldown = time.Minute
+	// magicLinkHourlyLimit caps the login links sent to an address per hour
+	magicLinkHourlyLimit = 5
+)
+
+var ErrInvalidMagicLink = errors.New("invalid or expired login link")
+
+type MagicLinkService interface {
+	SendMagicLink(ctx context.Context, email, locale string) error
+	ConsumeMagicLink(ctx context.Context, token string) (*models.User, error)
+}
+
+type magicLinkService struct {
+	userRepo    repository.UserRepository
+	tokenRepo   repository.OneTimeTokenRepository
+	notifier    Notifier
+	secret      string
+	allowSignUp bool
+}
+
+// NewMagicLinkService creates the service, allowSignUp lets a link sent to an unknown email create the user
+func NewMagicLinkService(
+	userRepo repository.UserRepository,
+	tokenRepo repository.OneTimeTokenRepository,
+	notifier Notifier,
+	secret string,
+	allowSignUp bool,
+) MagicLinkService {
+	return &magicLinkService{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		notifier:    notifier,
+		secret:      secret,
+		allowSignUp: allowSignUp,
+	}
+}
+
+// SendMagicLink emails a single use login link. It returns nil whether the account exists or not,
+// so that the endpoint can't be used to find accounts.
+func (s *magicLinkService) SendMagicLink(ctx context.Context, email, locale string) error {
+	recipient, err := s.userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		if !s.allowSignUp {
+			return nil
+		}
+		// The user is only created once the link proves they own the address
+		recipient = &models.User{Email: email, Locale: locale}
+	}
+
+	// Only the lookup runs before the reply, waiting for the token to be stored and for the mail server
+	// would make known emails answer measurably slower. The request context ends with the reply.
+	go func() {
+		if err := s.sendLink(context.Background(), recipient, email); err != nil {
+			log.Printf("Failed to send login link to %s: %v", email, err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *magicLinkService) sendLink(ctx context.Context, recipient *models.User, email string) error {
+	if throttled, err := s.isThrottled(ctx, email); err != nil || throttled {
+		return err
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate login token: %w", err)
+	}
+
+	stored := &models.OneTimeToken{
+		Purpose:   models.TokenPurposeMagicLink,
+		Email:     email,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(MagicLinkTTL),
+	}
+	if recipient.ID != uuid.Nil {
+		stored.UserID = &recipient.ID
+	}
+
+	if err := s.tokenRepo.Create(ctx, stored); err != nil {
+		return fmt.Errorf("failed to store login token: %w", err)
+	}
+
+	signed := utils.SignToken(s.secret, models.TokenPurposeMagicLink, token)
+	return s.notifier.SendMagicLink(ctx, recipient, signed)
+}
+
+// ConsumeMagicLink checks a login link and returns the user to sign in, creating it for a sign up link.
+// Opening the link proves the user owns the address, so the email is marked as verified.
+func (s *magicLinkService) ConsumeMagicLink(ctx context.Context, signed string) (*models.User, error) {
+	token, ok := utils.VerifySignedToken(s.secret, models.TokenPurposeMagicLink, signed)
+	if !ok {
+		return nil, ErrInvalidMagicLink
+	}
+
+	stored, err := s.tokenRepo.FindByHash(ctx, models.TokenPurposeMagicLink, utils.HashToken(token))
+	if err != nil || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidMagicLink
+	}
+
+	consumed, err := s.tokenRepo.MarkUsed(ctx, stored.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume login token: %w", err)
+	}
+	if !consumed {
+		return nil, ErrInvalidMagicLink
+	}
+
+	user, err := s.findOrCreateUser(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsEmailVerified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if err := s.userRepo.Update(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to mark email as verified: %w", err)
+		}
+	}
+
+	if err := s.tokenRepo.InvalidateByUserID(ctx, user.ID, models.TokenPurposeMagicLink); err != nil {
+		return nil, fmt.Errorf("failed to invalidate login tokens: %w", err)
+	}
+
+	return user, nil
+}
+
+// findOrCreateUser returns the owner of a login token. A link sent to a user who changed
+// their email since is rejected, a sign up link creates the user if nobody took the email meanwhile.
+func (s *magicLinkService) findOrCreateUser(ctx context.Context, stored *models.OneTimeToken) (*models.User, error) {
+	if stored.UserID != nil {
+		user, err := s.userRepo.FindByID(ctx, *stored.UserID)
+		if err != nil || user.Email != stored.Email {
+			return nil, ErrInvalidMagicLink
+		}
+		return user, nil
+	}
+
+	if user, err := s.userRepo.FindByEmail(ctx, stored.Email); err == nil {
+		return user, nil
+	}
+
+	if !s.allowSignUp {
+		return nil, ErrInvalidMagicLink
+	}
+
+	user := &models.User{
+		Name:  nameFromEmail(stored.Email),
+		Email: stored.Email,
+		Role:  "user",
+	}
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return user, nil
+}
+
+func (s *magicLinkService) isThrottled(ctx context.Context, email string) (bool, error) {
+	now := time.Now()
+
+	recent, err := s.tokenRepo.CountByEmailSince(ctx, email, models.TokenPurposeMagicLink, now.Add(-magicLinkCooldown))
+	if err != nil || recent > 0 {
+		return recent > 0, err
+	}
+
+	hourly, err := s.tokenRepo.CountByEmailSince(ctx, email, models.TokenPurposeMagicLink, now.Add(-time.Hour))
+	return hourly >= magicLinkHourlyLimit, err
+}
+
+// nameFromEmail gives a user created without a form a name they can change later
+func nameFromEmail(email string) string {
+	name, _, _ := strings.Cut(email, "@")
+	if len(name) < 3 {
+		return email
+	}
+	return name
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backend/pkg/models"
+)
+
+// A known email must not answer slower than an unknown one, the login link is stored and sent after the reply
+func TestSendMagicLinkDoesNotWaitForTheMail(t *testing.T) {
+	user := &models.User{Email: "jane@example.com"}
+	notifier := newBlockingNotifier()
+	tokens := &fakeOneTimeTokenRepo{}
+	service := NewMagicLinkService(newFakeUserRepo(user), tokens, notifier, "secret", false)
+
+	done := make(chan error, 1)
+	go func() { done <- service.SendMagicLink(context.Background(), user.Email, "en") }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("SendMagicLink() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SendMagicLink() waited for the mail to be sent")
+	}
+
+	close(notifier.release)
+	select {
+	case to := <-notifier.sent:
+		if to != user.Email {
+			t.Errorf("login link sent to %q, want %q", to, user.Email)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the login link was never sent")
+	}
+
+	tokens.mu.Lock()
+	defer tokens.mu.Unlock()
+	if len(tokens.tokens) != 1 || tokens.tokens[0].UserID == nil || *tokens.tokens[0].UserID != user.ID {
+		t.Errorf("stored tokens = %+v, want one for the user", tokens.tokens)
+	}
+}
+
+func TestSendMagicLinkIgnoresUnknownEmailsWithoutSignUp(t *testing.T) {
+	notifier := newBlockingNotifier()
+	close(notifier.release)
+	tokens := &fakeOneTimeTokenRepo{}
+	service := NewMagicLinkService(newFakeUserRepo(), tokens, notifier, "secret", false)
+
+	if err := service.SendMagicLink(context.Background(), "nobody@example.com", "en"); err != nil {
+		t.Fatalf("SendMagicLink() error = %v", err)
+	}
+
+	select {
+	case to := <-notifier.sent:
+		t.Errorf("login link sent to %q, want none", to)
+	case <-time.After(100 * time.Millisecond):
+	}
+	tokens.mu.Lock()
+	defer tokens.mu.Unlock()
+	if len(tokens.tokens) != 0 {
+		t.Errorf("stored %d tokens, want none", len(tokens.tokens))
+	}
+}
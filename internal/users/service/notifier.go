@@ -0,0 +1,66 @@
+package service
+
+import (
+	"backend/pkg/mailer"
+	"backend/pkg/models"
+	"context"
+	"net/url"
+	"strings"
+)
+
+// Notifier delivers the messages of the authentication flows to the users
+type Notifier interface {
+	SendEmailVerification(ctx context.Context, user *models.User, token string) error
+	SendPasswordReset(ctx context.Context, user *models.User, token string) error
+	SendPasswordChanged(ctx context.Context, user *models.User) error
+	SendMagicLink(ctx context.Context, user *models.User, token string) error
+}
+
+// mailNotifier sends the messages by email, in the locale of the user
+type mailNotifier struct {
+	outbox      *mailer.Outbox
+	frontendURL string
+}
+
+func NewMailNotifier(outbox *mailer.Outbox, frontendURL string) Notifier {
+	return &mailNotifier{
+		outbox:      outbox,
+		frontendURL: strings.TrimSuffix(frontendURL, "/"),
+	}
+}
+
+func (n *mailNotifier) SendEmailVerification(ctx context.Context, user *models.User, token string) error {
+	return n.outbox.SendTemplate(ctx, user.Email, user.Locale, "email_verification", map[string]interface{}{
+		"Name":           user.Name,
+		"Link":           n.link("/verify-email", token),
+		"ExpiresInHours": int(EmailVerificationTTL.Hours()),
+	})
+}
+
+func (n *mailNotifier) SendPasswordReset(ctx context.Context, user *models.User, token string) error {
+	return n.outbox.SendTemplate(ctx, user.Email, user.Locale, "password_reset", map[string]interface{}{
+		"Name":           user.Name,
+		"Link":           n.link("/reset-password", token),
+		"ExpiresInHours": int(PasswordResetTTL.Hours()),
+	})
+}
+
+func (n *mailNotifier) SendPasswordChanged(ctx context.Context, user *models.User) error {
+	return n.outbox.SendTemplate(ctx, user.Email, user.Locale, "password_changed", map[string]interface{}{
+		"Name": user.Name,
+	})
+}
+
+// SendMagicLink may be called with a user that doesn't exist yet, only Email and Locale are set then
+func (n *mailNotifier) SendMagicLink(ctx context.Context, user *models.User, token string) error {
+	return n.outbox.SendTemplate(ctx, user.Email, user.Locale, "magic_link", map[string]interface{}{
+		"Name":             user.Name,
+		"Link":             n.link("/magic-link", token),
+		"ExpiresInMinutes": int(MagicLinkTTL.Minutes()),
+	})
+}
+
+// link builds a frontend URL carrying a token in its query string
+func (n *mailNotifier) link(path, token string) string {
+	return n.frontendURL + path + "?token=" + url.QueryEscape(token)
+}
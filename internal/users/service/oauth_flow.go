@@ -0,0 +1,150 @@
+package service
+
+import (
+	"backend/pkg/oauth"
+	"backend/pkg/utils"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// oauthFlow runs the authorization code flow, shared by the sign in and the linking of accounts
+type oauthFlow struct {
+	providers *oauth.Registry
+}
+
+func newOAuthFlow(providers *oauth.Registry) oauthFlow {
+	return oauthFlow{providers: providers}
+}
+
+// redirectURL builds the authorization URL of a provider and the pending login to keep until the callback
+func (f oauthFlow) redirectURL(provider string) (string, *OAuthState, error) {
+	oauthProvider, err := f.providers.Get(provider)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pending := &OAuthState{
+		Provider:  provider,
+		State:     utils.GenerateRandomState(),
+		ExpiresAt: time.Now().Add(OAuthStateTTL),
+	}
+
+	var opts []oauth2.AuthCodeOption
+	if oauthProvider.UsePKCE() {
+		pending.CodeVerifier = oauth2.GenerateVerifier()
+		opts = append(opts, oauth2.S256ChallengeOption(pending.CodeVerifier))
+	}
+	if _, ok := oauthProvider.(oauth.IDTokenVerifier); ok {
+		pending.Nonce = utils.GenerateRandomState()
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", pending.Nonce))
+	}
+	if optioner, ok := oauthProvider.(oauth.AuthCodeOptioner); ok {
+		opts = append(opts, optioner.AuthCodeOptions()...)
+	}
+
+	oauthConfig := oauthProvider.OAuth2Config()
+	return oauthConfig.AuthCodeURL(pending.State, opts...), pending, nil
+}
+
+// verifyState checks the state returned by the provider against the pending login.
+// The caller is responsible for discarding the pending login so it can only be used once.
+func (f oauthFlow) verifyState(pending *OAuthState, provider, state string) error {
+	if state == "" {
+		return ErrOAuthStateMissing
+	}
+
+	if pending == nil || pending.State == "" {
+		return ErrOAuthStateNotFound
+	}
+
+	if time.Now().After(pending.ExpiresAt) {
+		return ErrOAuthStateExpired
+	}
+
+	if subtle.ConstantTimeCompare([]byte(pending.State), []byte(state)) != 1 {
+		return ErrOAuthStateMismatch
+	}
+
+	if pending.Provider != provider {
+		return ErrOAuthProviderMismatch
+	}
+
+	return nil
+}
+
+// userInfo completes the flow: it checks the state, exchanges the code and returns the profile of the user
+func (f oauthFlow) userInfo(ctx context.Context, provider, code, state string, pending *OAuthState) (*utils.UserInfo, error) {
+	if err := f.verifyState(pending, provider, state); err != nil {
+		return nil, err
+	}
+
+	if code == "" {
+		return nil, ErrOAuthCodeMissing
+	}
+
+	oauthProvider, err := f.providers.Get(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exchange code for token
+	token, err := f.exchangeCodeForToken(ctx, oauthProvider, code, pending.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	// Get user info from provider, from the ID token when the provider issues one
+	var userInfo *utils.UserInfo
+	if verifier, ok := oauthProvider.(oauth.IDTokenVerifier); ok {
+		userInfo, err = verifier.VerifyIDToken(ctx, token, pending.Nonce)
+	} else {
+		userInfo, err = oauthProvider.FetchUserInfo(ctx, token)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user info: %w", err)
+	}
+	if userInfo.Email == "" {
+		return nil, oauth.ErrEmailUnavailable
+	}
+
+	// Add token info to userInfo
+	userInfo.AccessToken = token.AccessToken
+	userInfo.TokenType = token.Type() // Use Type() method instead of direct access
+	userInfo.RefreshToken = token.RefreshToken
+	userInfo.Expiry = token.Expiry
+	userInfo.Scope = strings.Join(oauthProvider.Scopes(), " ")
+
+	return userInfo, nil
+}
+
+// tokenSource returns a source of valid tokens for a provider, starting from a stored token
+func (f oauthFlow) tokenSource(ctx context.Context, provider string, token *oauth2.Token) (oauth2.TokenSource, error) {
+	oauthProvider, err := f.providers.Get(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	config := oauthProvider.OAuth2Config()
+	return config.TokenSource(ctx, token), nil
+}
+
+func (f oauthFlow) exchangeCodeForToken(ctx context.Context, provider oauth.Provider, code, verifier string) (*oauth2.Token, error) {
+	config := provider.OAuth2Config()
+
+	var opts []oauth2.AuthCodeOption
+	if verifier != "" {
+		opts = append(opts, oauth2.VerifierOption(verifier))
+	}
+
+	token, err := config.Exchange(ctx, code, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange token: %v", err)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"backend/internal/users/repository"
+	"backend/pkg/models"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// PasskeyCeremonyTTL is how long the browser has to answer a WebAuthn challenge
+const PasskeyCeremonyTTL = 5 * time.Minute
+
+var (
+	ErrNoPasskeys         = errors.New("this user has no passkey")
+	ErrPasskeyRegistered  = errors.New("this passkey is already registered")
+	ErrInvalidPasskey     = errors.New("passkey verification failed")
+	ErrPasskeyCloneWarned = errors.New("passkey signature counter went backwards, the authenticator may be cloned")
+)
+
+type PasskeyService interface {
+	HasPasskeys(ctx context.Context, userID uuid.UUID) (bool, error)
+	BeginRegistration(ctx context.Context, userID uuid.UUID) (*protocol.CredentialCreation, *webauthn.SessionData, error)
+	FinishRegistration(ctx context.Context, userID uuid.UUID, session *webauthn.SessionData, name string, response []byte) (*models.Account, error)
+	BeginLogin(ctx context.Context) (*protocol.CredentialAssertion, *webauthn.SessionData, error)
+	FinishLogin(ctx context.Context, session *webauthn.SessionData, response []byte) (*models.User, error)
+	BeginSecondFactor(ctx context.Context, userID uuid.UUID) (*protocol.CredentialAssertion, *webauthn.SessionData, error)
+	FinishSecondFactor(ctx context.Context, userID uuid.UUID, session *webauthn.SessionData, response []byte) error
+}
+
+type passkeyService struct {
+	userRepo    repository.UserRepository
+	accountRepo repository.AccountRepository
+	webAuthn    *webauthn.WebAuthn
+}
+
+// NewPasskeyService configures the relying party, rpID is the domain the passkeys are bound to
+func NewPasskeyService(
+	userRepo repository.UserRepository,
+	accountRepo repository.AccountRepository,
+	rpID string,
+	rpName string,
+	rpOrigins []string,
+) (PasskeyService, error) {
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     rpOrigins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: PasskeyCeremonyTTL, TimeoutUVD: PasskeyCeremonyTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: PasskeyCeremonyTTL, TimeoutUVD: PasskeyCeremonyTTL},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebAuthn configuration: %w", err)
+	}
+
+	return &passkeyService{
+		userRepo:    userRepo,
+		accountRepo: accountRepo,
+		webAuthn:    webAuthn,
+	}, nil
+}
+
+func (s *passkeyService) HasPasskeys(ctx context.Context, userID uuid.UUID) (bool, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return len(passkeyAccounts(user)) > 0, nil
+}
+
+// BeginRegistration creates the options of navigator.credentials.create() for a signed in user
+func (s *passkeyService) BeginRegistration(ctx context.Context, userID uuid.UUID) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+
+	holder := &passkeyUser{user: user}
+
+	// The authenticator refuses to register a second passkey for the same user
+	var exclusions []protocol.CredentialDescriptor
+	for _, credential := range holder.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	// Discoverable credentials are what allows signing in without typing an email
+	return s.webAuthn.BeginRegistration(holder,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+}
+
+// FinishRegistration checks the attestation against the pending ceremony and stores the passkey as a new account
+func (s *passkeyService) FinishRegistration(ctx context.Context, userID uuid.UUID, session *webauthn.SessionData, name string, response []byte) (*models.Account, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	credential, err := s.webAuthn.CreateCredential(&passkeyUser{user: user}, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	credentialID := encodeCredentialID(credential.ID)
+	if _, err := s.accountRepo.FindByProviderID(ctx, models.AccountTypeWebAuthn, credentialID); err == nil {
+		return nil, ErrPasskeyRegistered
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+
+	account := &models.Account{
+		UserID:            user.ID,
+		Type:              models.AccountTypeWebAuthn,
+		Provider:          models.AccountTypeWebAuthn,
+		ProviderAccountID: credentialID,
+		Name:              name,
+		PublicKey:         credential.PublicKey,
+		SignCount:         int64(credential.Authenticator.SignCount),
+		Transports:        strings.Join(transports, ","),
+		AttestationType:   credential.AttestationType,
+		BackupEligible:    credential.Flags.BackupEligible,
+		BackupState:       credential.Flags.BackupState,
+	}
+
+	if err := s.accountRepo.Create(ctx, account); err != nil {
+		return nil, fmt.Errorf("failed to store passkey: %w", err)
+	}
+
+	return account, nil
+}
+
+// BeginLogin starts a passwordless login, the browser lets the user pick any passkey of this site.
+// User verification is required so the passkey alone is a multi-factor login.
+func (s *passkeyService) BeginLogin(ctx context.Context) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return s.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+}
+
+// FinishLogin finds the user from the user handle of the assertion and checks it against their passkeys
+func (s *passkeyService) FinishLogin(ctx context.Context, session *webauthn.SessionData, response []byte) (*models.User, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		user, err := s.userRepo.FindByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		return &passkeyUser{user: user}, nil
+	}
+
+	holder, credential, err := s.webAuthn.ValidatePasskeyLogin(findUser, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	user := holder.(*passkeyUser).user
+	if err := s.recordUse(ctx, user, credential); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// BeginSecondFactor asks for one of the passkeys of a user who already passed the first factor
+func (s *passkeyService) BeginSecondFactor(ctx context.Context, userID uuid.UUID) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+
+	if len(passkeyAccounts(user)) == 0 {
+		return nil, nil, ErrNoPasskeys
+	}
+
+	return s.webAuthn.BeginLogin(&passkeyUser{user: user})
+}
+
+func (s *passkeyService) FinishSecondFactor(ctx context.Context, userID uuid.UUID, session *webauthn.SessionData, response []byte) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	credential, err := s.webAuthn.ValidateLogin(&passkeyUser{user: user}, *session, parsed)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	return s.recordUse(ctx, user, credential)
+}
+
+// recordUse stores the new signature counter, a counter that didn't increase hints at a cloned authenticator
+func (s *passkeyService) recordUse(ctx context.Context, user *models.User, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return ErrPasskeyCloneWarned
+	}
+
+	credentialID := encodeCredentialID(credential.ID)
+	for _, account := range passkeyAccounts(user) {
+		if account.ProviderAccountID != credentialID {
+			continue
+		}
+
+		now := time.Now()
+		account.SignCount = int64(credential.Authenticator.SignCount)
+		account.BackupState = credential.Flags.BackupState
+		account.LastUsedAt = &now
+		if err := s.accountRepo.Update(ctx, account); err != nil {
+			return fmt.Errorf("failed to update passkey: %w", err)
+		}
+		return nil
+	}
+
+	return ErrInvalidPasskey
+}
+
+// passkeyUser adapts a user loaded with its accounts to webauthn.User
+type passkeyUser struct {
+	user *models.User
+}
+
+// WebAuthnID is the user handle stored in the passkey, the user ID keeps it free of personal data
+func (u *passkeyUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	accounts := passkeyAccounts(u.user)
+	credentials := make([]webauthn.Credential, 0, len(accounts))
+	for _, account := range accounts {
+		id, err := base64.RawURLEncoding.DecodeString(account.ProviderAccountID)
+		if err != nil {
+			continue
+		}
+
+		var transports []protocol.AuthenticatorTransport
+		for _, transport := range strings.Split(account.Transports, ",") {
+			if transport != "" {
+				transports = append(transports, protocol.AuthenticatorTransport(transport))
+			}
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              id,
+			PublicKey:       account.PublicKey,
+			AttestationType: account.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: account.BackupEligible,
+				BackupState:    account.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{SignCount: uint32(account.SignCount)},
+		})
+	}
+	return credentials
+}
+
+// passkeyAccounts returns the passkeys of a user loaded with its accounts
+func passkeyAccounts(user *models.User) []*models.Account {
+	var accounts []*models.Account
+	for i := range user.Accounts {
+		if user.Accounts[i].Type == models.AccountTypeWebAuthn {
+			accounts = append(accounts, &user.Accounts[i])
+		}
+	}
+	return accounts
+}
+
+func encodeCredentialID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
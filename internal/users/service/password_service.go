@@ -0,0 +1,226 @@
+package service
+
+import (
+	"backend/internal/users/repository"
+	"backend/pkg/models"
+	"backend/pkg/security"
+	"backend/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// PasswordResetTTL is how long a password reset link stays valid
+	PasswordResetTTL = time.Hour
+	// passwordResetCooldown is the minimum delay between two reset emails
+	passwordResetCooldown = time.Minute
+	// passwordResetHourlyLimit caps the reset emails sent to a user per hour
+	passwordResetHourlyLimit = 5
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	ErrNoPassword        = errors.New("this user has no password")
+)
+
+type PasswordService interface {
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, newPassword string) (*models.User, error)
+	ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword string) error
+}
+
+type passwordService struct {
+	userRepo         repository.UserRepository
+	accountRepo      repository.AccountRepository
+	oneTimeTokenRepo repository.OneTimeTokenRepository
+	refreshTokenRepo repository.RefreshTokenRepository
+	notifier         Notifier
+	hasher           *utils.PasswordHasher
+	policy           *security.PasswordPolicy
+	secret           string
+}
+
+func NewPasswordService(
+	userRepo repository.UserRepository,
+	accountRepo repository.AccountRepository,
+	oneTimeTokenRepo repository.OneTimeTokenRepository,
+	refreshTokenRepo repository.RefreshTokenRepository,
+	notifier Notifier,
+	hasher *utils.PasswordHasher,
+	policy *security.PasswordPolicy,
+	secret string,
+) PasswordService {
+	return &passwordService{
+		userRepo:         userRepo,
+		accountRepo:      accountRepo,
+		oneTimeTokenRepo: oneTimeTokenRepo,
+		refreshTokenRepo: refreshTokenRepo,
+		notifier:         notifier,
+		hasher:           hasher,
+		policy:           policy,
+		secret:           secret,
+	}
+}
+
+// ForgotPassword sends a reset link to the user owning email. It returns nil whether the
+// account exists or not, so that the endpoint can't be used to find accounts.
+func (s *passwordService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		return nil
+	}
+
+	if _, ok := credentialsAccount(user); !ok {
+		return nil
+	}
+
+	if throttled, err := s.isThrottled(ctx, user.ID); err != nil || throttled {
+		return err
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	if err := s.oneTimeTokenRepo.Create(ctx, &models.OneTimeToken{
+		UserID:    &user.ID,
+		Purpose:   models.TokenPurposePasswordReset,
+		Email:     user.Email,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(PasswordResetTTL),
+	}); err != nil {
+		return fmt.Errorf("failed to store reset token: %w", err)
+	}
+
+	// Sent in the background, waiting for the mail server would make known emails answer measurably slower.
+	// The request context is not used, it ends with the response.
+	signed := utils.SignToken(s.secret, models.TokenPurposePasswordReset, token)
+	go func() {
+		if err := s.notifier.SendPasswordReset(context.Background(), user, signed); err != nil {
+			log.Printf("Failed to send password reset to %s: %v", user.Email, err)
+		}
+	}()
+
+	return nil
+}
+
+// ResetPassword consumes a reset token and sets a new password. The caller must revoke the sessions of the returned user.
+// A password rejected by the policy returns a *security.PasswordPolicyError and leaves the token usable.
+func (s *passwordService) ResetPassword(ctx context.Context, signed, newPassword string) (*models.User, error) {
+	token, ok := utils.VerifySignedToken(s.secret, models.TokenPurposePasswordReset, signed)
+	if !ok {
+		return nil, ErrInvalidResetToken
+	}
+
+	stored, err := s.oneTimeTokenRepo.FindByHash(ctx, models.TokenPurposePasswordReset, utils.HashToken(token))
+	if err != nil || stored.UserID == nil || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidResetToken
+	}
+
+	user, err := s.userRepo.FindByID(ctx, *stored.UserID)
+	if err != nil || user.Email != stored.Email {
+		return nil, ErrInvalidResetToken
+	}
+
+	if err := s.policy.Validate(newPassword, user.Email, user.Name); err != nil {
+		return nil, err
+	}
+
+	consumed, err := s.oneTimeTokenRepo.MarkUsed(ctx, stored.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume reset token: %w", err)
+	}
+	if !consumed {
+		return nil, ErrInvalidResetToken
+	}
+
+	if err := s.setPassword(ctx, user, newPassword); err != nil {
+		return nil, err
+	}
+
+	if err := s.oneTimeTokenRepo.InvalidateByUserID(ctx, user.ID, models.TokenPurposePasswordReset); err != nil {
+		return nil, fmt.Errorf("failed to invalidate reset tokens: %w", err)
+	}
+
+	return user, nil
+}
+
+// ChangePassword replaces the password of a user after checking the current one.
+// The caller must revoke the other sessions of the user.
+func (s *passwordService) ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword string) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	account, ok := credentialsAccount(user)
+	if !ok {
+		return ErrNoPassword
+	}
+
+	if match, _ := s.hasher.Verify(currentPassword, account.Password); !match {
+		return ErrInvalidCredentials
+	}
+
+	if err := s.policy.Validate(newPassword, user.Email, user.Name); err != nil {
+		return err
+	}
+
+	return s.setPassword(ctx, user, newPassword)
+}
+
+// setPassword stores the new hash and revokes the refresh tokens issued with the old password
+func (s *passwordService) setPassword(ctx context.Context, user *models.User, newPassword string) error {
+	account, ok := credentialsAccount(user)
+	if !ok {
+		return ErrNoPassword
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	account.Password = hashedPassword
+	if err := s.accountRepo.Update(ctx, account); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err := s.refreshTokenRepo.RevokeByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	if err := s.notifier.SendPasswordChanged(ctx, user); err != nil {
+		log.Printf("Failed to send password change notice to %s: %v", user.Email, err)
+	}
+
+	return nil
+}
+
+func (s *passwordService) isThrottled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	now := time.Now()
+
+	recent, err := s.oneTimeTokenRepo.CountSince(ctx, userID, models.TokenPurposePasswordReset, now.Add(-passwordResetCooldown))
+	if err != nil || recent > 0 {
+		return recent > 0, err
+	}
+
+	hourly, err := s.oneTimeTokenRepo.CountSince(ctx, userID, models.TokenPurposePasswordReset, now.Add(-time.Hour))
+	return hourly >= passwordResetHourlyLimit, err
+}
+
+// credentialsAccount returns the password account of a user loaded with its accounts
+func credentialsAccount(user *models.User) (*models.Account, bool) {
+	for i := range user.Accounts {
+		if user.Accounts[i].Type == models.AccountTypeCredentials {
+			return &user.Accounts[i], true
+		}
+	}
+	return nil, false
+}
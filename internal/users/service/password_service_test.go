@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backend/pkg/models"
+)
+
+// A known email must not answer slower than an unknown one, the reset mail is sent after the reply
+func TestForgotPasswordDoesNotWaitForTheMail(t *testing.T) {
+	user := &models.User{
+		Email:    "jane@example.com",
+		Accounts: []models.Account{{Type: models.AccountTypeCredentials, Provider: "credentials"}},
+	}
+	notifier := newBlockingNotifier()
+	service := NewPasswordService(newFakeUserRepo(user), newFakeAccountRepo(), &fakeOneTimeTokenRepo{}, nil, notifier, nil, nil, "secret")
+
+	done := make(chan error, 1)
+	go func() { done <- service.ForgotPassword(context.Background(), user.Email) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("ForgotPassword() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ForgotPassword() waited for the mail to be sent")
+	}
+
+	close(notifier.release)
+	select {
+	case to := <-notifier.sent:
+		if to != user.Email {
+			t.Errorf("reset sent to %q, want %q", to, user.Email)
+		}
+	case <-time.After(time.Second):
+		t.Error("the reset mail was never sent")
+	}
+}
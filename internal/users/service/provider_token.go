@@ -0,0 +1,28 @@
+package service
+
+import (
+	"backend/pkg/models"
+
+	"golang.org/x/oauth2"
+)
+
+// setProviderToken stores a provider token on an account, the columns are encrypted at rest.
+// Providers don't always send a new refresh token, the stored one is kept in that case.
+func setProviderToken(account *models.Account, token *oauth2.Token) {
+	account.AccessToken = token.AccessToken
+	if token.RefreshToken != "" {
+		account.RefreshToken = token.RefreshToken
+	}
+	account.TokenType = token.Type()
+	account.ExpiresAt = token.Expiry
+}
+
+// providerToken returns the provider token stored on an account by setProviderToken
+func providerToken(account *models.Account) *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  account.AccessToken,
+		TokenType:    account.TokenType,
+		RefreshToken: account.RefreshToken,
+		Expiry:       account.ExpiresAt,
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"backend/internal/users/repository"
+	"backend/pkg/models"
+	"backend/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, all tokens of this login were revoked")
+)
+
+// TokenPair is returned to the clients that authenticate with bearer tokens instead of cookies
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // Seconds until the access token expires
+}
+
+type TokenService interface {
+	Issue(ctx context.Context, user *models.User) (*TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (*TokenPair, error)
+	Revoke(ctx context.Context, refreshToken string) error
+	RevokeAll(ctx context.Context, userID uuid.UUID) error
+}
+
+type tokenService struct {
+	userRepo        repository.UserRepository
+	tokenRepo       repository.RefreshTokenRepository
+	secret          string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+}
+
+func NewTokenService(
+	userRepo repository.UserRepository,
+	tokenRepo repository.RefreshTokenRepository,
+	secret string,
+	accessTokenTTL time.Duration,
+	refreshTokenTTL time.Duration,
+) TokenService {
+	return &tokenService{
+		userRepo:        userRepo,
+		tokenRepo:       tokenRepo,
+		secret:          secret,
+		accessTokenTTL:  accessTokenTTL,
+		refreshTokenTTL: refreshTokenTTL,
+	}
+}
+
+// Issue starts a new token family for the user
+func (s *tokenService) Issue(ctx context.Context, user *models.User) (*TokenPair, error) {
+	return s.issue(ctx, user, uuid.New())
+}
+
+// Refresh rotates a refresh token. Presenting a token that was already rotated means it leaked,
+// so the whole family is revoked and the legitimate client has to log in again.
+func (s *tokenService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	stored, err := s.tokenRepo.FindByHash(ctx, utils.HashToken(refreshToken))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if stored.UsedAt != nil {
+		return nil, s.revokeReusedFamily(ctx, stored)
+	}
+
+	// Only one concurrent request can rotate the token, the other one is treated as a reuse
+	rotated, err := s.tokenRepo.MarkUsed(ctx, stored.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if !rotated {
+		return nil, s.revokeReusedFamily(ctx, stored)
+	}
+
+	user, err := s.userRepo.FindByID(ctx, stored.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.issue(ctx, user, stored.FamilyID)
+}
+
+// Revoke invalidates the family of a refresh token, unknown tokens are ignored
+func (s *tokenService) Revoke(ctx context.Context, refreshToken string) error {
+	stored, err := s.tokenRepo.FindByHash(ctx, utils.HashToken(refreshToken))
+	if err != nil {
+		return nil
+	}
+	return s.tokenRepo.RevokeFamily(ctx, stored.FamilyID)
+}
+
+// RevokeAll invalidates every refresh token of a user
+func (s *tokenService) RevokeAll(ctx context.Context, userID uuid.UUID) error {
+	return s.tokenRepo.RevokeByUserID(ctx, userID)
+}
+
+func (s *tokenService) issue(ctx context.Context, user *models.User, familyID uuid.UUID) (*TokenPair, error) {
+	accessToken, err := utils.GenerateAccessToken(s.secret, user.ID, user.Email, user.Role, s.accessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	refreshToken, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	if err := s.tokenRepo.Create(ctx, &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(s.refreshTokenTTL),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *tokenService) revokeReusedFamily(ctx context.Context, stored *models.RefreshToken) error {
+	if err := s.tokenRepo.RevokeFamily(ctx, stored.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+	return ErrRefreshTokenReused
+}
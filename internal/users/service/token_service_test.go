@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/pkg/models"
+	"backend/pkg/utils"
+)
+
+func newTestTokenService(t *testing.T) (TokenService, *models.User) {
+	t.Helper()
+	user := &models.User{Email: "jane@example.com", Role: "user"}
+	return NewTokenService(newFakeUserRepo(user), &fakeRefreshTokenRepo{}, "secret", time.Minute, time.Hour), user
+}
+
+func TestTokenServiceRefreshRotates(t *testing.T) {
+	service, user := newTestTokenService(t)
+	ctx := context.Background()
+
+	issued, err := service.Issue(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := utils.ParseAccessToken("secret", issued.AccessToken)
+	if err != nil || claims.Subject != user.ID.String() {
+		t.Fatalf("access token claims = %+v, %v", claims, err)
+	}
+
+	rotated, err := service.Refresh(ctx, issued.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if rotated.RefreshToken == issued.RefreshToken {
+		t.Error("Refresh() returned the same refresh token")
+	}
+	if _, err := service.Refresh(ctx, rotated.RefreshToken); err != nil {
+		t.Errorf("Refresh() of the rotated token error = %v", err)
+	}
+
+	if _, err := service.Refresh(ctx, "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Refresh() of an unknown token = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+// A rotated token presented again leaked, the whole family goes and the other logins stay
+func TestTokenServiceRefreshReuseRevokesTheFamily(t *testing.T) {
+	service, user := newTestTokenService(t)
+	ctx := context.Background()
+
+	stolen, err := service.Issue(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherLogin, err := service.Issue(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := service.Refresh(ctx, stolen.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.Refresh(ctx, stolen.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Refresh() of a rotated token = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := service.Refresh(ctx, latest.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Refresh() of the latest token of the family = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := service.Refresh(ctx, otherLogin.RefreshToken); err != nil {
+		t.Errorf("Refresh() of another login = %v, want nil", err)
+	}
+}
+
+// Two requests racing with the same token can't both rotate it, the loser counts as a reuse
+func TestTokenServiceConcurrentRefresh(t *testing.T) {
+	service, user := newTestTokenService(t)
+	ctx := context.Background()
+
+	issued, err := service.Issue(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const requests = 8
+	errs := make(chan error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.Refresh(ctx, issued.RefreshToken)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrRefreshTokenReused) && !errors.Is(err, ErrInvalidRefreshToken):
+			t.Errorf("Refresh() error = %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d concurrent refreshes succeeded, want one", succeeded)
+	}
+}
+
+func TestTokenServiceRevoke(t *testing.T) {
+	service, user := newTestTokenService(t)
+	ctx := context.Background()
+
+	issued, err := service.Issue(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Revoke(ctx, issued.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Refresh(ctx, issued.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Refresh() of a revoked token = %v, want ErrInvalidRefreshToken", err)
+	}
+	if err := service.Revoke(ctx, "unknown"); err != nil {
+		t.Errorf("Revoke() of an unknown token = %v, want nil", err)
+	}
+}
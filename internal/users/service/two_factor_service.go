@@ -0,0 +1,187 @@
+package service
+
+import (
+	"backend/internal/users/repository"
+	"backend/pkg/models"
+	"backend/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// recoveryCodeCount is the number of recovery codes generated when 2FA is turned on
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("no pending two-factor enrollment")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+)
+
+// TOTPEnrollment is shown once to the user so they can add the secret to an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TwoFactorService interface {
+	IsEnabled(ctx context.Context, userID uuid.UUID) (bool, error)
+	BeginEnrollment(ctx context.Context, userID uuid.UUID) (*TOTPEnrollment, error)
+	ConfirmEnrollment(ctx context.Context, userID uuid.UUID, code string) ([]string, error)
+	Disable(ctx context.Context, userID uuid.UUID, code string) error
+	Verify(ctx context.Context, userID uuid.UUID, code string) error
+}
+
+type twoFactorService struct {
+	userRepo      repository.UserRepository
+	twoFactorRepo repository.TwoFactorRepository
+	issuer        string
+}
+
+func NewTwoFactorService(
+	userRepo repository.UserRepository,
+	twoFactorRepo repository.TwoFactorRepository,
+	issuer string,
+) TwoFactorService {
+	return &twoFactorService{
+		userRepo:      userRepo,
+		twoFactorRepo: twoFactorRepo,
+		issuer:        issuer,
+	}
+}
+
+func (s *twoFactorService) IsEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	twoFactor, err := s.twoFactorRepo.FindByUserID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return twoFactor.IsEnabled(), nil
+}
+
+// BeginEnrollment generates a new secret for any user, every sign in method asks for it once enabled.
+// It stays inactive until confirmed with a code.
+func (s *twoFactorService) BeginEnrollment(ctx context.Context, userID uuid.UUID) (*TOTPEnrollment, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	enabled, err := s.IsEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	if err := s.twoFactorRepo.Save(ctx, &models.TwoFactor{
+		UserID: userID,
+		Secret: secret,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    utils.TOTPURI(s.issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmEnrollment turns 2FA on once the user proved their authenticator works.
+// The recovery codes are returned in clear only here, only their hashes are stored.
+func (s *twoFactorService) ConfirmEnrollment(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	twoFactor, err := s.twoFactorRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if twoFactor.IsEnabled() {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	step, err := s.checkTOTP(twoFactor, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = utils.GenerateRecoveryCode(); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		hashes[i] = utils.HashToken(utils.NormalizeRecoveryCode(codes[i]))
+	}
+
+	now := time.Now()
+	twoFactor.EnabledAt = &now
+	twoFactor.LastUsedStep = step
+	if err := s.twoFactorRepo.Enable(ctx, twoFactor, hashes); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	return codes, nil
+}
+
+// Disable turns 2FA off, a valid TOTP or recovery code is required
+func (s *twoFactorService) Disable(ctx context.Context, userID uuid.UUID, code string) error {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return err
+	}
+	return s.twoFactorRepo.Delete(ctx, userID)
+}
+
+// Verify accepts a TOTP code or an unused recovery code, each one only once
+func (s *twoFactorService) Verify(ctx context.Context, userID uuid.UUID, code string) error {
+	twoFactor, err := s.twoFactorRepo.FindByUserID(ctx, userID)
+	if err != nil || !twoFactor.IsEnabled() {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, err := s.checkTOTP(twoFactor, code); err == nil {
+		advanced, err := s.twoFactorRepo.AdvanceStep(ctx, twoFactor.ID, step)
+		if err != nil {
+			return fmt.Errorf("failed to record TOTP step: %w", err)
+		}
+		if !advanced {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	recoveryCode, err := s.twoFactorRepo.FindUnusedRecoveryCode(ctx, userID, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	if err != nil {
+		return ErrInvalidTwoFactorCode
+	}
+
+	consumed, err := s.twoFactorRepo.MarkRecoveryCodeUsed(ctx, recoveryCode.ID)
+	if err != nil {
+		return fmt.Errorf("failed to consume recovery code: %w", err)
+	}
+	if !consumed {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// checkTOTP validates a code against the stored secret and rejects steps already used
+func (s *twoFactorService) checkTOTP(twoFactor *models.TwoFactor, code string) (int64, error) {
+	step, ok := utils.ValidateTOTP(twoFactor.Secret, code, time.Now())
+	if !ok || step <= twoFactor.LastUsedStep {
+		return 0, ErrInvalidTwoFactorCode
+	}
+	return step, nil
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"backend/pkg/models"
+)
+
+// A user who only signs in with a provider can protect the account with a second factor too
+func TestBeginEnrollmentWithoutPassword(t *testing.T) {
+	user := &models.User{
+		Email:    "jane@example.com",
+		Accounts: []models.Account{{Provider: "github", ProviderAccountID: "42"}},
+	}
+	twoFactorRepo := newFakeTwoFactorRepo()
+	service := NewTwoFactorService(newFakeUserRepo(user), twoFactorRepo, "Backend")
+
+	enrollment, err := service.BeginEnrollment(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("BeginEnrollment() error = %v", err)
+	}
+	if enrollment.Secret == "" || enrollment.URI == "" {
+		t.Errorf("enrollment = %+v, want a secret and its URI", enrollment)
+	}
+	if _, err := twoFactorRepo.FindByUserID(context.Background(), user.ID); err != nil {
+		t.Errorf("the pending secret was not stored: %v", err)
+	}
+}
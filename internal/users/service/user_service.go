@@ -12,6 +12,7 @@ type UserService interface {
 	Create(ctx context.Context, user *models.User) error
 	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
 	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	IsEmailVerified(ctx context.Context, id uuid.UUID) (bool, error)
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id uuid.UUID) error
 }
@@ -36,6 +37,14 @@ func (s *userService) GetByEmail(ctx context.Context, email string) (*models.Use
 	return s.userRepo.FindByEmail(ctx, email)
 }
 
+func (s *userService) IsEmailVerified(ctx context.Context, id uuid.UUID) (bool, error) {
+	user, err := s.userRepo.FindByID(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return user.IsEmailVerified(), nil
+}
+
 func (s *userService) Update(ctx context.Context, user *models.User) error {
 	return s.userRepo.Update(ctx, user)
 }
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"backend/internal/users/repository"
+	"backend/pkg/models"
+	"backend/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// EmailVerificationTTL is how long a verification link stays valid
+	EmailVerificationTTL = 24 * time.Hour
+	// emailVerificationCooldown is the minimum delay between two verification emails
+	emailVerificationCooldown = time.Minute
+	// emailVerificationHourlyLimit caps the verification emails sent to a user per hour
+	emailVerificationHourlyLimit = 5
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrEmailAlreadyVerified     = errors.New("email is already verified")
+	ErrVerificationThrottled    = errors.New("too many verification emails, please try again later")
+)
+
+type VerificationService interface {
+	SendEmailVerification(ctx context.Context, user *models.User) error
+	ResendEmailVerification(ctx context.Context, userID uuid.UUID) error
+	VerifyEmail(ctx context.Context, token string) (*models.User, error)
+}
+
+type verificationService struct {
+	userRepo  repository.UserRepository
+	tokenRepo repository.OneTimeTokenRepository
+	notifier  Notifier
+	secret    string
+}
+
+func NewVerificationService(
+	userRepo repository.UserRepository,
+	tokenRepo repository.OneTimeTokenRepository,
+	notifier Notifier,
+	secret string,
+) VerificationService {
+	return &verificationService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		notifier:  notifier,
+		secret:    secret,
+	}
+}
+
+// SendEmailVerification issues a verification token for the current email of the user and sends it
+func (s *verificationService) SendEmailVerification(ctx context.Context, user *models.User) error {
+	if user.IsEmailVerified() {
+		return ErrEmailAlreadyVerified
+	}
+
+	if err := s.checkThrottle(ctx, user.ID); err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	if err := s.tokenRepo.Create(ctx, &models.OneTimeToken{
+		UserID:    &user.ID,
+		Purpose:   models.TokenPurposeEmailVerification,
+		Email:     user.Email,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(EmailVerificationTTL),
+	}); err != nil {
+		return fmt.Errorf("failed to store verification token: %w", err)
+	}
+
+	signed := utils.SignToken(s.secret, models.TokenPurposeEmailVerification, token)
+	return s.notifier.SendEmailVerification(ctx, user, signed)
+}
+
+func (s *verificationService) ResendEmailVerification(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	return s.SendEmailVerification(ctx, user)
+}
+
+// VerifyEmail consumes a verification token and marks the email it was sent to as verified
+func (s *verificationService) VerifyEmail(ctx context.Context, signed string) (*models.User, error) {
+	token, ok := utils.VerifySignedToken(s.secret, models.TokenPurposeEmailVerification, signed)
+	if !ok {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	stored, err := s.tokenRepo.FindByHash(ctx, models.TokenPurposeEmailVerification, utils.HashToken(token))
+	if err != nil || stored.UserID == nil || stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	consumed, err := s.tokenRepo.MarkUsed(ctx, stored.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume verification token: %w", err)
+	}
+	if !consumed {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	user, err := s.userRepo.FindByID(ctx, *stored.UserID)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	// The user changed their email since the token was sent
+	if user.Email != stored.Email {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if !user.IsEmailVerified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if err := s.userRepo.Update(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+
+	// Links sent before this one are useless now
+	if err := s.tokenRepo.InvalidateByUserID(ctx, user.ID, models.TokenPurposeEmailVerification); err != nil {
+		return nil, fmt.Errorf("failed to invalidate verification tokens: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *verificationService) checkThrottle(ctx context.Context, userID uuid.UUID) error {
+	now := time.Now()
+
+	recent, err := s.tokenRepo.CountSince(ctx, userID, models.TokenPurposeEmailVerification, now.Add(-emailVerificationCooldown))
+	if err != nil {
+		return err
+	}
+	if recent > 0 {
+		return ErrVerificationThrottled
+	}
+
+	hourly, err := s.tokenRepo.CountSince(ctx, userID, models.TokenPurposeEmailVerification, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if hourly >= emailVerificationHourlyLimit {
+		return ErrVerificationThrottled
+	}
+
+	return nil
+}
@@ -1,21 +1,89 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/gofiber/storage/redis"
-	"golang.org/x/oauth2"
 )
 
 // Config is the main configuration struct
 type Config struct {
-	Port      string
-	Env       string
-	JWTSecret string
-	Database  struct {
+	Port                 string
+	Env                  string
+	AppName              string // Shown to users, e.g. as the issuer in authenticator apps
+	JWTSecret            string
+	EncryptionKey        []byte            // AES-256 key of the secrets encrypted before the keyring, and its only key when ENCRYPTION_KEYS is empty
+	EncryptionKeys       map[string][]byte // Keyring of the encrypted columns by key ID, old keys stay to decrypt until the rows are re-encrypted
+	EncryptionKeyID      string            // ID of the key new values are encrypted with
+	AccessTokenTTL       time.Duration     // Lifetime of the access JWTs issued by /auth/token
+	RefreshTokenTTL      time.Duration     // Lifetime of a refresh token, each rotation issues a new one
+	RequireVerifiedEmail bool              // Reject users with an unverified email on the /users routes
+	MagicLinkSignUp      bool              // A login link requested for an unknown email creates the user, otherwise nothing is sent
+	OAuthEmailLinking    string            // verified: an OAuth sign in joins the user with the same email if both the provider and the user verified it, never: only explicit links
+	Session              struct {
+		IdleTimeout       time.Duration // A session unused for this long is signed out
+		AbsoluteTimeout   time.Duration // A session is signed out this long after the login, however active
+		RememberMeTimeout time.Duration // Idle timeout of "remember me" sessions, which have no absolute one
+	}
+	Frontend struct {
+		URL            string   // Default destination after an OAuth sign in
+		AllowedOrigins []string // Origins a return_to URL may point to
+	}
+	Password struct {
+		Algorithm         string // argon2id or bcrypt, existing hashes of the other one still verify
+		Argon2Memory      int    // KiB
+		Argon2Iterations  int
+		Argon2Parallelism int
+		BcryptCost        int
+	}
+	PasswordPolicy struct {
+		MinLength          int
+		MaxLength          int
+		RequireUppercase   bool
+		RequireLowercase   bool
+		RequireDigit       bool
+		RequireSymbol      bool
+		RejectPersonalInfo bool   // Reject passwords containing the email or the name of the user
+		BreachedFile       string // One password or SHA-1 hash per line, loaded in memory
+		BreachedDir        string // k-anonymity layout: <first 5 hex of the SHA-1>.txt files of "SUFFIX:COUNT" lines
+	}
+	LoginProtection struct {
+		MaxAccountFailures int           // Failed logins before an account is locked
+		MaxIPFailures      int           // Failed logins before an IP is locked, whatever the accounts
+		BackoffAfter       int           // Failed logins before each new attempt has to wait
+		BackoffBase        time.Duration // First wait, doubled on each failure
+		BackoffMax         time.Duration
+		FailureWindow      time.Duration // How long a failed login is remembered
+		LockoutDuration    time.Duration
+	}
+	WebAuthn struct {
+		RPID      string   // Domain the passkeys are bound to, e.g. "example.com"
+		RPOrigins []string // Origins allowed to run the ceremonies, the frontend URL by default
+	}
+	Mail struct {
+		Transport     string // smtp, file, log or memory
+		From          string // Default sender
+		DefaultLocale string // Template locale used when the user's one has no variant
+		Dir           string // Output directory of the file transport
+		SMTP          struct {
+			Host     string
+			Port     int
+			Username string
+			Password string
+			TLS      string // starttls, tls or none
+		}
+	}
+	Database struct {
 		Host     string
 		Port     string
 		User     string
@@ -31,24 +99,13 @@ type OAuthConfig struct {
 	ClientSecret string
 	RedirectURL  string
 	Scopes       []string
+	UsePKCE      bool              // Send a S256 code challenge, disable for providers that reject it
+	IssuerURL    string            // OpenID Connect issuer, only used by generic OIDC providers
+	Settings     map[string]string // Provider specific variables, e.g. BASE_URL read from GITLAB_BASE_URL
 }
 
-// OAuthProviders is the configuration struc containing all OAuth providers
-type OAuthProviders struct {
-	Google  OAuthConfig
-	Discord OAuthConfig
-}
-
-var (
-	GoogleEndpoints = oauth2.Endpoint{
-		AuthURL:  "https://accounts.google.com/o/oauth2/auth",
-		TokenURL: "https://oauth2.googleapis.com/token",
-	}
-	DiscordEnpoints = oauth2.Endpoint{
-		AuthURL:  "https://discord.com/api/oauth2/authorize",
-		TokenURL: "https://discord.com/api/oauth2/token",
-	}
-)
+// OAuthProviders maps each OAuth provider name to its configuration
+type OAuthProviders map[string]OAuthConfig
 
 func getEnv(key, defaultValue string) string {
 	value, exists := os.LookupEnv(key)
@@ -69,12 +126,122 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return value
 }
 
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, strconv.FormatBool(defaultValue))
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		log.Printf("Error converting %s to boolean, using default: %t", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr := getEnv(key, defaultValue.String())
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		log.Printf("Error converting %s to duration, using default: %s", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvAsOneOf reads a variable restricted to a few values, any other one stops the startup
+// rather than silently falling back to a behavior the operator didn't ask for
+func getEnvAsOneOf(key, defaultValue string, allowed ...string) string {
+	value := getEnv(key, defaultValue)
+	if value == "" {
+		return defaultValue
+	}
+	if !slices.Contains(allowed, value) {
+		log.Fatalf("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value)
+	}
+	return value
+}
+
+func getEnvAsSlice(key string, defaultValue []string) []string {
+	valueStr := getEnv(key, strings.Join(defaultValue, ","))
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	var values []string
+	for _, value := range strings.Split(valueStr, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func LoadConfig() *Config {
 	cfg := &Config{
 		Port:      getEnv("PORT", "3000"),
 		Env:       getEnv("ENV", "dev"),
+		AppName:   getEnv("APP_NAME", "Fiber API"),
 		JWTSecret: getEnv("JWT_SECRET", "thisisaverylongsecret"),
+
+		AccessTokenTTL:  getEnvAsDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvAsDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		RequireVerifiedEmail: getEnvAsBool("REQUIRE_VERIFIED_EMAIL", false),
+		MagicLinkSignUp:      getEnvAsBool("MAGIC_LINK_SIGN_UP", false),
+		OAuthEmailLinking:    getEnvAsOneOf("OAUTH_EMAIL_LINKING", "verified", "verified", "never"),
+	}
+
+	// A wrong key can't be noticed until the data it protects is read, so it stops the startup
+	var err error
+	if cfg.EncryptionKey, err = loadEncryptionKey(cfg.JWTSecret, os.Getenv("ENV") == "dev"); err != nil {
+		log.Fatal(err)
 	}
+	if cfg.EncryptionKeys, cfg.EncryptionKeyID, err = loadEncryptionKeys(cfg.EncryptionKey); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.Session.IdleTimeout = getEnvAsDuration("SESSION_IDLE_TIMEOUT", 30*time.Minute)
+	cfg.Session.AbsoluteTimeout = getEnvAsDuration("SESSION_ABSOLUTE_TIMEOUT", 24*time.Hour)
+	cfg.Session.RememberMeTimeout = getEnvAsDuration("SESSION_REMEMBER_ME_TIMEOUT", 30*24*time.Hour)
+
+	cfg.Frontend.URL = getEnv("FRONTEND_URL", "http://localhost:3000")
+	cfg.Frontend.AllowedOrigins = getEnvAsSlice("FRONTEND_ALLOWED_ORIGINS", []string{cfg.Frontend.URL})
+
+	// Defaults follow the OWASP password storage recommendations
+	cfg.Password.Algorithm = getEnv("PASSWORD_ALGORITHM", "argon2id")
+	cfg.Password.Argon2Memory = getEnvAsInt("PASSWORD_ARGON2_MEMORY", 19*1024)
+	cfg.Password.Argon2Iterations = getEnvAsInt("PASSWORD_ARGON2_ITERATIONS", 2)
+	cfg.Password.Argon2Parallelism = getEnvAsInt("PASSWORD_ARGON2_PARALLELISM", 1)
+	cfg.Password.BcryptCost = getEnvAsInt("PASSWORD_BCRYPT_COST", 12)
+
+	cfg.PasswordPolicy.MinLength = getEnvAsInt("PASSWORD_MIN_LENGTH", 8)
+	cfg.PasswordPolicy.MaxLength = getEnvAsInt("PASSWORD_MAX_LENGTH", 128)
+	cfg.PasswordPolicy.RequireUppercase = getEnvAsBool("PASSWORD_REQUIRE_UPPERCASE", false)
+	cfg.PasswordPolicy.RequireLowercase = getEnvAsBool("PASSWORD_REQUIRE_LOWERCASE", false)
+	cfg.PasswordPolicy.RequireDigit = getEnvAsBool("PASSWORD_REQUIRE_DIGIT", false)
+	cfg.PasswordPolicy.RequireSymbol = getEnvAsBool("PASSWORD_REQUIRE_SYMBOL", false)
+	cfg.PasswordPolicy.RejectPersonalInfo = getEnvAsBool("PASSWORD_REJECT_PERSONAL_INFO", true)
+	cfg.PasswordPolicy.BreachedFile = getEnv("PASSWORD_BREACHED_FILE", "")
+	cfg.PasswordPolicy.BreachedDir = getEnv("PASSWORD_BREACHED_DIR", "")
+
+	cfg.LoginProtection.MaxAccountFailures = getEnvAsInt("LOGIN_MAX_ACCOUNT_FAILURES", 10)
+	cfg.LoginProtection.MaxIPFailures = getEnvAsInt("LOGIN_MAX_IP_FAILURES", 50)
+	cfg.LoginProtection.BackoffAfter = getEnvAsInt("LOGIN_BACKOFF_AFTER", 3)
+	cfg.LoginProtection.BackoffBase = getEnvAsDuration("LOGIN_BACKOFF_BASE", time.Second)
+	cfg.LoginProtection.BackoffMax = getEnvAsDuration("LOGIN_BACKOFF_MAX", time.Minute)
+	cfg.LoginProtection.FailureWindow = getEnvAsDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+	cfg.LoginProtection.LockoutDuration = getEnvAsDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+
+	cfg.WebAuthn.RPID = getEnv("WEBAUTHN_RP_ID", "localhost")
+	cfg.WebAuthn.RPOrigins = getEnvAsSlice("WEBAUTHN_RP_ORIGINS", []string{cfg.Frontend.URL})
+
+	cfg.Mail.Transport = getEnv("MAIL_TRANSPORT", "log")
+	cfg.Mail.From = getEnv("MAIL_FROM", "Fiber API <no-reply@localhost>")
+	cfg.Mail.DefaultLocale = getEnv("MAIL_DEFAULT_LOCALE", "en")
+	cfg.Mail.Dir = getEnv("MAIL_DIR", "tmp/mails")
+	cfg.Mail.SMTP.Host = getEnv("SMTP_HOST", "localhost")
+	cfg.Mail.SMTP.Port = getEnvAsInt("SMTP_PORT", 587)
+	cfg.Mail.SMTP.Username = getEnv("SMTP_USERNAME", "")
+	cfg.Mail.SMTP.Password = getEnv("SMTP_PASSWORD", "")
+	cfg.Mail.SMTP.TLS = getEnv("SMTP_TLS", "starttls")
 
 	cfg.Database.Host = getEnv("POSTGRES_HOST", "localhost")
 	cfg.Database.Port = getEnv("POSTGRES_PORT", "5432")
@@ -85,52 +252,120 @@ func LoadConfig() *Config {
 	return cfg
 }
 
-func LoadOAuthConfig() *OAuthProviders {
-	return &OAuthProviders{
-		Google: OAuthConfig{
-			Provider:     "google",
-			ClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
-			ClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
-			RedirectURL:  getEnv("GOOGLE_REDIRECT_URL", "http://localhost:3000/api/v1/auth/callback/google"),
-			Scopes:       []string{"profile", "email"},
-		},
-		Discord: OAuthConfig{
-			Provider:     "discord",
-			ClientID:     getEnv("DISCORD_CLIENT_ID", ""),
-			ClientSecret: getEnv("DISCORD_CLIENT_SECRET", ""),
-			RedirectURL:  getEnv("DISCORD_REDIRECT_URL", "http://localhost:3000/api/v1/auth/callback/discord"),
-			Scopes:       []string{"identify", "email"},
-		},
+// loadEncryptionKey decodes ENCRYPTION_KEY (base64, 32 bytes). It may be left empty when ENCRYPTION_KEYS is set.
+// Only with ENV=dev a missing key is derived from the JWT secret, so that development setups keep working.
+func loadEncryptionKey(jwtSecret string, devMode bool) ([]byte, error) {
+	value := getEnv("ENCRYPTION_KEY", "")
+	if value == "" {
+		if !devMode {
+			return nil, nil
+		}
+		log.Printf("ENCRYPTION_KEY not set, deriving it from JWT_SECRET, never do this outside development")
+		key := sha256.Sum256([]byte("encryption:" + jwtSecret))
+		return key[:], nil
 	}
+
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("ENCRYPTION_KEY must be 32 bytes encoded in base64")
+	}
+	return key, nil
 }
 
-func (c *OAuthConfig) ToOAuth2Config() oauth2.Config {
-	var endpoint oauth2.Endpoint
+// loadEncryptionKeys decodes ENCRYPTION_KEYS, comma separated "<id>:<base64 key>" entries, and
+// ENCRYPTION_KEY_ID, the key of new values which defaults to the first entry.
+// Without ENCRYPTION_KEYS the keyring only holds ENCRYPTION_KEY, under the ID "default".
+func loadEncryptionKeys(legacyKey []byte) (map[string][]byte, string, error) {
+	entries := getEnvAsSlice("ENCRYPTION_KEYS", nil)
+	if len(entries) == 0 {
+		if legacyKey == nil {
+			return nil, "", errors.New("ENCRYPTION_KEY or ENCRYPTION_KEYS must be set")
+		}
+		return map[string][]byte{"default": legacyKey}, encryptionKeyID("default"), nil
+	}
+
+	keys := make(map[string][]byte, len(entries))
+	var firstID string
+	for _, entry := range entries {
+		id, value, found := strings.Cut(entry, ":")
+		key, err := base64.StdEncoding.DecodeString(value)
+		if !found || id == "" || err != nil || len(key) != 32 {
+			return nil, "", fmt.Errorf("ENCRYPTION_KEYS entries must be <id>:<32 bytes encoded in base64>, got one for %q", id)
+		}
+		if _, exists := keys[id]; exists {
+			return nil, "", fmt.Errorf("ENCRYPTION_KEYS has the key %q twice", id)
+		}
+		keys[id] = key
+		if firstID == "" {
+			firstID = id
+		}
+	}
+
+	return keys, encryptionKeyID(firstID), nil
+}
 
-	switch c.Provider {
-	case "google":
-		endpoint = GoogleEndpoints
-	case "discord":
-		endpoint = DiscordEnpoints
+// encryptionKeyID reads ENCRYPTION_KEY_ID, an empty value like the one of .env.example means the default
+func encryptionKeyID(defaultID string) string {
+	if id := getEnv("ENCRYPTION_KEY_ID", defaultID); id != "" {
+		return id
 	}
+	return defaultID
+}
 
-	return oauth2.Config{
-		ClientID:     c.ClientID,
-		ClientSecret: c.ClientSecret,
-		RedirectURL:  c.RedirectURL,
-		Scopes:       c.Scopes,
-		Endpoint:     endpoint,
+// LoadOAuthConfig loads the configuration of the given providers.
+// Each provider reads <NAME>_CLIENT_ID, <NAME>_CLIENT_SECRET, <NAME>_REDIRECT_URL,
+// <NAME>_SCOPES (comma separated, provider defaults when empty) and <NAME>_PKCE,
+// then <NAME>_<SETTING> for each of the settings the provider declares, empty when unset.
+func LoadOAuthConfig(providers map[string][]string) OAuthProviders {
+	configs := make(OAuthProviders, len(providers))
+	for provider, settings := range providers {
+		cfg := loadOAuthProviderConfig(provider)
+		for _, setting := range settings {
+			cfg.Settings[setting] = getEnv(strings.ToUpper(provider)+"_"+setting, "")
+		}
+		configs[provider] = cfg
 	}
+	return configs
 }
 
-func SetupSessionStore() *session.Store {
-	storage := redis.New(redis.Config{
+// LoadOIDCConfig loads the generic OpenID Connect providers listed in OIDC_PROVIDERS
+// (e.g. "keycloak,authentik"). On top of the usual OAuth variables, each one reads <NAME>_ISSUER_URL.
+func LoadOIDCConfig() OAuthProviders {
+	providers := getEnvAsSlice("OIDC_PROVIDERS", nil)
+	configs := make(OAuthProviders, len(providers))
+	for _, provider := range providers {
+		provider = strings.ToLower(provider)
+		cfg := loadOAuthProviderConfig(provider)
+		cfg.IssuerURL = getEnv(strings.ToUpper(provider)+"_ISSUER_URL", "")
+		configs[provider] = cfg
+	}
+	return configs
+}
+
+func loadOAuthProviderConfig(provider string) OAuthConfig {
+	prefix := strings.ToUpper(provider)
+	return OAuthConfig{
+		Provider:     provider,
+		ClientID:     getEnv(prefix+"_CLIENT_ID", ""),
+		ClientSecret: getEnv(prefix+"_CLIENT_SECRET", ""),
+		RedirectURL:  getEnv(prefix+"_REDIRECT_URL", "http://localhost:3000/api/v1/auth/callback/"+provider),
+		Scopes:       getEnvAsSlice(prefix+"_SCOPES", nil),
+		UsePKCE:      getEnvAsBool(prefix+"_PKCE", true),
+		Settings:     make(map[string]string),
+	}
+}
+
+// SetupRedisStorage connects to the Redis instance shared by the sessions and the auth counters
+func SetupRedisStorage() *redis.Storage {
+	return redis.New(redis.Config{
 		Host:     getEnv("REDIS_HOST", "redis"),
 		Port:     getEnvAsInt("REDIS_PORT", 6379),
 		Password: getEnv("REDIS_PASSWORD", ""),
 		Database: getEnvAsInt("REDIS_DB", 0),
 	})
+}
 
+func SetupSessionStore(storage *redis.Storage) *session.Store {
 	sessionStore := session.New(session.Config{
 		Storage:        storage,
 		CookieSecure:   getEnv("ENV", "dev") == "prod", // HTTPS only
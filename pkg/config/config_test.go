@@ -0,0 +1,76 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestGetEnvAsOneOf(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"never", "never"},
+		{"verified", "verified"},
+		{"", "verified"},
+	}
+	for _, tt := range tests {
+		t.Setenv("OAUTH_EMAIL_LINKING", tt.value)
+		if got := getEnvAsOneOf("OAUTH_EMAIL_LINKING", "verified", "verified", "never"); got != tt.want {
+			t.Errorf("getEnvAsOneOf(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	tests := []struct {
+		name    string
+		value   string
+		devMode bool
+		wantKey bool
+		wantErr bool
+	}{
+		{"valid key", valid, false, true, false},
+		{"missing key", "", false, false, false},
+		{"missing key in dev mode", "", true, true, false},
+		{"short key", base64.StdEncoding.EncodeToString([]byte("short")), false, false, true},
+		{"invalid key in dev mode", "not base64!", true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENCRYPTION_KEY", tt.value)
+			key, err := loadEncryptionKey("thisisaverylongsecret", tt.devMode)
+			if (err != nil) != tt.wantErr || (key != nil) != tt.wantKey {
+				t.Errorf("loadEncryptionKey() = %v, %v", key, err)
+			}
+		})
+	}
+}
+
+func TestLoadEncryptionKeys(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	legacyKey := bytes.Repeat([]byte{1}, 32)
+
+	t.Setenv("ENCRYPTION_KEY_ID", "")
+	t.Setenv("ENCRYPTION_KEYS", "")
+	if _, _, err := loadEncryptionKeys(nil); err == nil {
+		t.Error("loadEncryptionKeys() without any key succeeded")
+	}
+	if keys, id, err := loadEncryptionKeys(legacyKey); err != nil || id != "default" || !bytes.Equal(keys["default"], legacyKey) {
+		t.Errorf("loadEncryptionKeys() = %v, %q, %v, want the legacy key as default", keys, id, err)
+	}
+
+	t.Setenv("ENCRYPTION_KEYS", "2024:"+valid+",2025:"+valid)
+	if keys, id, err := loadEncryptionKeys(nil); err != nil || id != "2024" || len(keys) != 2 {
+		t.Errorf("loadEncryptionKeys() = %v, %q, %v", keys, id, err)
+	}
+
+	for _, entries := range []string{"2024:" + valid + ",2025:short", "2024:" + valid + ",2024:" + valid, ":" + valid} {
+		t.Setenv("ENCRYPTION_KEYS", entries)
+		if _, _, err := loadEncryptionKeys(legacyKey); err == nil {
+			t.Errorf("loadEncryptionKeys(%q) accepted a bad entry", entries)
+		}
+	}
+}
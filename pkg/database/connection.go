@@ -4,12 +4,20 @@ import (
 	"fmt"
 	"backend/pkg/config"
 	"backend/pkg/models"
+	"backend/pkg/security"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 func ConnectDB(cfg *config.Config) (*gorm.DB, error) {
+	// The encrypted columns can't be read or written without it
+	ring, err := security.NewKeyring(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the encryption keys: %w", err)
+	}
+	keyring = ring
+
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		cfg.Database.Host, cfg.Database.User, cfg.Database.Password, cfg.Database.DBName, cfg.Database.Port)
 
@@ -30,9 +38,40 @@ func ConnectDB(cfg *config.Config) (*gorm.DB, error) {
 	return db, nil
 }
 
+// migratedModels are the tables managed by AutoMigrate
+var migratedModels = []interface{}{
+	&models.User{},
+	&models.Account{},
+	&models.RefreshToken{},
+	&models.OneTimeToken{},
+	&models.TwoFactor{},
+	&models.RecoveryCode{},
+	&models.SecurityEvent{},
+}
+
 func runMigrations(db *gorm.DB) error {
-	return db.AutoMigrate(
-		&models.User{},
-		&models.Account{},
-	)
+	return db.AutoMigrate(migratedModels...)
+}
+
+// EncryptedColumn is a column written through the encrypted serializer
+type EncryptedColumn struct {
+	Table  string
+	Column string
+}
+
+// EncryptedColumns lists the encrypted columns of the migrated models
+func EncryptedColumns(db *gorm.DB) ([]EncryptedColumn, error) {
+	var columns []EncryptedColumn
+	for _, model := range migratedModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.TagSettings["SERIALIZER"] == EncryptedSerializer {
+				columns = append(columns, EncryptedColumn{Table: stmt.Schema.Table, Column: field.DBName})
+			}
+		}
+	}
+	return columns, nil
 }
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"backend/pkg/security"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm/schema"
+)
+
+// EncryptedSerializer is the name of the serializer of the columns encrypted at rest,
+// used on string fields as `gorm:"serializer:encrypted"`
+const EncryptedSerializer = "encrypted"
+
+var errNoKeyring = errors.New("database: the keyring of the encrypted columns is not set")
+
+// keyring is set by ConnectDB, serializers are registered globally in GORM
+var keyring *security.Keyring
+
+// plaintextColumns remembers the columns already reported as holding values in clear
+var plaintextColumns sync.Map
+
+func init() {
+	schema.RegisterSerializer(EncryptedSerializer, encryptedSerializer{})
+}
+
+// encryptedSerializer encrypts a string field with the keyring on write and decrypts it on read.
+// Empty strings are stored as is, so that a missing secret stays recognizable. A value that doesn't have the
+// shape of a ciphertext is read in clear, it predates the encryption of its column. A ciphertext that doesn't
+// decrypt is an error, e.g. a wrong legacy key, never a value to hand back.
+type encryptedSerializer struct{}
+
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("database: unsupported type %T for the encrypted column %s", dbValue, field.DBName)
+	}
+
+	if value != "" {
+		if keyring == nil {
+			return errNoKeyring
+		}
+		plaintext, err := keyring.Decrypt(value)
+		switch {
+		case err == nil:
+			value = plaintext
+		case !keyring.IsEncrypted(value):
+			// Rows written before the column was encrypted are read as they are until cmd/reencrypt -plaintext runs,
+			// they are encrypted on their next write anyway
+			if _, reported := plaintextColumns.LoadOrStore(field.DBName, true); !reported {
+				log.Printf("database: %s holds values that are not encrypted, run cmd/reencrypt -plaintext", field.DBName)
+			}
+		default:
+			return fmt.Errorf("database: failed to decrypt %s: %w", field.DBName, err)
+		}
+	}
+
+	return field.Set(ctx, dst, value)
+}
+
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("database: unsupported type %T for the encrypted column %s", fieldValue, field.DBName)
+	}
+	if plaintext == "" {
+		return "", nil
+	}
+
+	if keyring == nil {
+		return nil, errNoKeyring
+	}
+	return keyring.Encrypt(plaintext)
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/security"
+	"backend/pkg/utils"
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+)
+
+type secretRow struct {
+	Secret string `gorm:"serializer:encrypted"`
+}
+
+func newTestKeyring(t *testing.T, legacyKey []byte, keys map[string][]byte, currentID string) *security.Keyring {
+	t.Helper()
+	testKeyring, err := security.NewKeyring(&config.Config{
+		EncryptionKey:   legacyKey,
+		EncryptionKeys:  keys,
+		EncryptionKeyID: currentID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeyring
+}
+
+func setTestKeyring(t *testing.T) *security.Keyring {
+	t.Helper()
+	legacyKey := bytes.Repeat([]byte{1}, 32)
+	keyring = newTestKeyring(t, legacyKey, map[string][]byte{"v1": legacyKey, "v2": bytes.Repeat([]byte{2}, 32)}, "v2")
+	t.Cleanup(func() { keyring = nil })
+	return keyring
+}
+
+// scanSecret reads dbValue into a secretRow through the serializer
+func scanSecret(t *testing.T, dbValue string) (string, error) {
+	t.Helper()
+	rowSchema, err := schema.Parse(&secretRow{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row secretRow
+	err = encryptedSerializer{}.Scan(context.Background(), rowSchema.LookUpField("Secret"), reflect.ValueOf(&row).Elem(), dbValue)
+	return row.Secret, err
+}
+
+func TestEncryptedScanDecrypts(t *testing.T) {
+	testKeyring := setTestKeyring(t)
+	encrypted, err := testKeyring.Encrypt("provider-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := scanSecret(t, encrypted)
+	if err != nil || got != "provider-token" {
+		t.Errorf("Scan() = %q, %v, want provider-token", got, err)
+	}
+}
+
+// Rows written before the column was encrypted must stay readable until they are re-encrypted
+func TestEncryptedScanAcceptsLegacyPlaintext(t *testing.T) {
+	setTestKeyring(t)
+
+	got, err := scanSecret(t, "ya29.plaintext-token")
+	if err != nil || got != "ya29.plaintext-token" {
+		t.Errorf("Scan() = %q, %v, want the value in clear", got, err)
+	}
+}
+
+func TestEncryptedScanRejectsUnknownKey(t *testing.T) {
+	setTestKeyring(t)
+	other := newTestKeyring(t, nil, map[string][]byte{"v0": bytes.Repeat([]byte{9}, 32)}, "v0")
+	encrypted, err := other.Encrypt("provider-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = scanSecret(t, encrypted)
+	if !errors.Is(err, security.ErrUnknownEncryptionKey) {
+		t.Errorf("Scan() error = %v, want ErrUnknownEncryptionKey", err)
+	}
+}
+
+// A value encrypted before the keyring under another legacy key is a ciphertext, never a plaintext to hand back
+func TestEncryptedScanRejectsLegacyCiphertextUnderAWrongKey(t *testing.T) {
+	setTestKeyring(t)
+	legacy, err := utils.Encrypt(bytes.Repeat([]byte{3}, 32), "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := scanSecret(t, legacy)
+	if !errors.Is(err, utils.ErrDecryptionFailed) {
+		t.Errorf("Scan() = %q, %v, want ErrDecryptionFailed", got, err)
+	}
+}
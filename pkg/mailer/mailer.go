@@ -0,0 +1,163 @@
+package mailer
+
+import (
+	"backend/pkg/config"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var ErrInvalidHeader = errors.New("mailer: header contains a line break")
+
+// Message is an email with a plain text body and an optional HTML alternative
+type Message struct {
+	From    string // Optional, the transport default sender is used when empty
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends emails through a transport
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New builds the transport selected by cfg.Mail.Transport: smtp, file, log or memory
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.Mail.Transport {
+	case "smtp":
+		return NewSMTPMailer(SMTPConfig{
+			Host:     cfg.Mail.SMTP.Host,
+			Port:     cfg.Mail.SMTP.Port,
+			Username: cfg.Mail.SMTP.Username,
+			Password: cfg.Mail.SMTP.Password,
+			TLS:      cfg.Mail.SMTP.TLS,
+			From:     cfg.Mail.From,
+		})
+	case "file":
+		return NewFileMailer(cfg.Mail.Dir, cfg.Mail.From)
+	case "log", "":
+		return NewLogMailer(cfg.Mail.From), nil
+	case "memory":
+		return NewMemoryMailer(cfg.Mail.From), nil
+	default:
+		return nil, fmt.Errorf("mailer: unknown transport %q", cfg.Mail.Transport)
+	}
+}
+
+// withDefaults returns a copy of msg with the default sender applied and its headers checked
+func withDefaults(msg *Message, from string) (*Message, error) {
+	out := *msg
+	if out.From == "" {
+		out.From = from
+	}
+
+	if out.From == "" {
+		return nil, errors.New("mailer: sender is missing")
+	}
+	if len(out.To) == 0 {
+		return nil, errors.New("mailer: recipient is missing")
+	}
+
+	for _, value := range append([]string{out.From, out.Subject}, out.To...) {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+	for _, address := range append([]string{out.From}, out.To...) {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return nil, fmt.Errorf("mailer: invalid address %q: %w", address, err)
+		}
+	}
+
+	return &out, nil
+}
+
+// addressOnly strips the display name of an address for the SMTP envelope
+func addressOnly(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	return parsed.Address
+}
+
+// encode renders msg as a MIME message, multipart/alternative when it has an HTML body
+func encode(msg *Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: %s\r\n", messageID(msg.From))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	writer := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID(from string) string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	domain := "localhost"
+	if _, host, found := strings.Cut(addressOnly(from), "@"); found {
+		domain = host
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
@@ -0,0 +1,26 @@
+package mailer
+
+import "context"
+
+// Outbox renders a template and sends the result through a Mailer
+type Outbox struct {
+	mailer    Mailer
+	templates *Templates
+}
+
+func NewOutbox(mailer Mailer, templates *Templates) *Outbox {
+	return &Outbox{
+		mailer:    mailer,
+		templates: templates,
+	}
+}
+
+// SendTemplate renders the template name in the recipient locale and sends it to them
+func (o *Outbox) SendTemplate(ctx context.Context, to, locale, name string, data interface{}) error {
+	msg, err := o.templates.Render(name, locale, data)
+	if err != nil {
+		return err
+	}
+	msg.To = []string{to}
+	return o.mailer.Send(ctx, msg)
+}
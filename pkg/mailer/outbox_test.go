@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOutboxSendTemplate(t *testing.T) {
+	memory := NewMemoryMailer("noreply@example.com")
+	outbox := NewOutbox(memory, testTemplates(t))
+
+	if err := outbox.SendTemplate(context.Background(), "jane@example.com", "fr-CA", "welcome", map[string]string{"Name": "Jane"}); err != nil {
+		t.Fatalf("SendTemplate() error = %v", err)
+	}
+
+	msg, ok := memory.Last()
+	if !ok {
+		t.Fatal("no email sent")
+	}
+	if len(msg.To) != 1 || msg.To[0] != "jane@example.com" {
+		t.Errorf("To = %v", msg.To)
+	}
+	if msg.From != "noreply@example.com" {
+		t.Errorf("From = %q, want the default sender", msg.From)
+	}
+	if msg.Subject != "Bienvenue" || msg.Text != "Bonjour Jane\n" {
+		t.Errorf("message = %q %q, want the French variant", msg.Subject, msg.Text)
+	}
+}
+
+func TestOutboxSendTemplateUnknown(t *testing.T) {
+	memory := NewMemoryMailer("noreply@example.com")
+	outbox := NewOutbox(memory, testTemplates(t))
+
+	if err := outbox.SendTemplate(context.Background(), "jane@example.com", "en", "missing", nil); err == nil {
+		t.Error("SendTemplate() of an unknown template succeeded")
+	}
+	if len(memory.Messages()) != 0 {
+		t.Error("an email was sent for an unknown template")
+	}
+}
@@ -0,0 +1,156 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// smtpServer is a minimal SMTP server accepting one message, it records the commands it received
+type smtpServer struct {
+	listener net.Listener
+	startTLS bool
+	commands chan []string
+}
+
+func newSMTPServer(t *testing.T, startTLS bool) *smtpServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &smtpServer{listener: listener, startTLS: startTLS, commands: make(chan []string, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var commands []string
+	defer func() { s.commands <- commands }()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		commands = append(commands, line)
+
+		switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+		case "EHLO":
+			if s.startTLS {
+				reply("250-localhost")
+				reply("250 STARTTLS")
+			} else {
+				reply("250 localhost")
+			}
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var body strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				body.WriteString(line)
+			}
+			commands = append(commands, body.String())
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *smtpServer) mailer(t *testing.T, tlsMode string) *SMTPMailer {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	m, err := NewSMTPMailer(SMTPConfig{Host: host, Port: portNumber, TLS: tlsMode, From: "App <noreply@example.com>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := newSMTPServer(t, false)
+	m := server.mailer(t, SMTPNone)
+
+	err := m.Send(context.Background(), &Message{
+		To:      []string{"Jane <jane@example.com>"},
+		Subject: "Hello",
+		Text:    "Plain body",
+		HTML:    "<p>HTML body</p>",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	commands := <-server.commands
+	session := strings.Join(commands, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<noreply@example.com>",
+		"RCPT TO:<jane@example.com>",
+		"From: App <noreply@example.com>",
+		"Subject: Hello",
+		"Content-Type: multipart/alternative",
+		"Plain body",
+		"<p>HTML body</p>",
+		"QUIT",
+	} {
+		if !strings.Contains(session, want) {
+			t.Errorf("SMTP session lacks %q:\n%s", want, session)
+		}
+	}
+}
+
+// The default mode must not fall back to plain text when the server can't encrypt
+func TestSMTPMailerRequiresStartTLS(t *testing.T) {
+	server := newSMTPServer(t, false)
+	m := server.mailer(t, "")
+
+	err := m.Send(context.Background(), &Message{To: []string{"jane@example.com"}, Subject: "Hello", Text: "Secret"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("Send() error = %v, want a STARTTLS error", err)
+	}
+
+	for _, command := range <-server.commands {
+		if strings.HasPrefix(command, "MAIL") {
+			t.Errorf("the message was sent without STARTTLS: %q", command)
+		}
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	m, err := NewSMTPMailer(SMTPConfig{Host: "localhost", From: "noreply@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send(context.Background(), &Message{To: []string{"jane@example.com"}, Subject: "Hello\r\nBcc: eve@example.com"})
+	if !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Send() error = %v, want ErrInvalidHeader", err)
+	}
+}
@@ -0,0 +1,130 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+// Templates renders the emails from templates/<locale>/<name>.txt and templates/<locale>/<name>.html.
+// The text template defines the subject in a "subject" block, the HTML one is optional.
+type Templates struct {
+	defaultLocale string
+	text          map[string]*texttemplate.Template
+	html          map[string]*htmltemplate.Template
+}
+
+// NewTemplates parses the embedded templates, defaultLocale is used when a locale has no variant
+func NewTemplates(defaultLocale string) (*Templates, error) {
+	sub, err := fs.Sub(templatesFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplates(sub, defaultLocale)
+}
+
+// ParseTemplates parses the templates found in fsys, laid out as <locale>/<name>.{txt,html}
+func ParseTemplates(fsys fs.FS, defaultLocale string) (*Templates, error) {
+	t := &Templates{
+		defaultLocale: defaultLocale,
+		text:          make(map[string]*texttemplate.Template),
+		html:          make(map[string]*htmltemplate.Template),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+
+		key := strings.TrimSuffix(file, path.Ext(file))
+		switch path.Ext(file) {
+		case ".txt":
+			tmpl, err := texttemplate.New(file).Parse(string(content))
+			if err != nil {
+				return fmt.Errorf("mailer: failed parsing %s: %w", file, err)
+			}
+			if tmpl.Lookup("subject") == nil {
+				return fmt.Errorf("mailer: %s has no subject block", file)
+			}
+			t.text[key] = tmpl
+		case ".html":
+			tmpl, err := htmltemplate.New(file).Parse(string(content))
+			if err != nil {
+				return fmt.Errorf("mailer: failed parsing %s: %w", file, err)
+			}
+			t.html[key] = tmpl
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Render builds the message for the template name in the closest available locale.
+// "fr-CA" falls back to "fr", then to the default locale.
+func (t *Templates) Render(name, locale string, data interface{}) (*Message, error) {
+	key, ok := t.resolve(name, locale)
+	if !ok {
+		return nil, fmt.Errorf("mailer: no template %s", name)
+	}
+
+	var subject, text bytes.Buffer
+	if err := t.text[key].ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("mailer: failed rendering %s subject: %w", key, err)
+	}
+	if err := t.text[key].Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("mailer: failed rendering %s: %w", key, err)
+	}
+
+	msg := &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}
+
+	if tmpl, ok := t.html[key]; ok {
+		var html bytes.Buffer
+		if err := tmpl.Execute(&html, data); err != nil {
+			return nil, fmt.Errorf("mailer: failed rendering %s HTML: %w", key, err)
+		}
+		msg.HTML = html.String()
+	}
+
+	return msg, nil
+}
+
+func (t *Templates) resolve(name, locale string) (string, bool) {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+
+	candidates := []string{locale}
+	if language, _, found := strings.Cut(locale, "-"); found {
+		candidates = append(candidates, language)
+	}
+	candidates = append(candidates, t.defaultLocale)
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		key := candidate + "/" + name
+		if _, ok := t.text[key]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testTemplates(t *testing.T) *Templates {
+	t.Helper()
+	templates, err := ParseTemplates(fstest.MapFS{
+		"en/welcome.txt":  {Data: []byte(`{{define "subject"}}Welcome{{end}}Hello {{.Name}}`)},
+		"en/welcome.html": {Data: []byte(`<p>Hello {{.Name}}</p>`)},
+		"fr/welcome.txt":  {Data: []byte(`{{define "subject"}}Bienvenue{{end}}Bonjour {{.Name}}`)},
+	}, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return templates
+}
+
+func TestTemplatesRenderLocaleFallback(t *testing.T) {
+	templates := testTemplates(t)
+
+	tests := []struct {
+		locale      string
+		wantSubject string
+	}{
+		{"fr", "Bienvenue"},
+		{"fr-CA", "Bienvenue"},
+		{"fr_CA", "Bienvenue"},
+		{"FR", "Bienvenue"},
+		{"de", "Welcome"},
+		{"", "Welcome"},
+	}
+	for _, tt := range tests {
+		msg, err := templates.Render("welcome", tt.locale, map[string]string{"Name": "Jane"})
+		if err != nil {
+			t.Fatalf("Render(%q) error = %v", tt.locale, err)
+		}
+		if msg.Subject != tt.wantSubject {
+			t.Errorf("Render(%q) subject = %q, want %q", tt.locale, msg.Subject, tt.wantSubject)
+		}
+	}
+}
+
+func TestTemplatesRenderBodies(t *testing.T) {
+	templates := testTemplates(t)
+
+	msg, err := templates.Render("welcome", "en", map[string]string{"Name": "<Jane>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "Hello <Jane>\n" {
+		t.Errorf("Text = %q", msg.Text)
+	}
+	if msg.HTML != "<p>Hello &lt;Jane&gt;</p>" {
+		t.Errorf("HTML = %q, want the name escaped", msg.HTML)
+	}
+
+	// The French variant has no HTML, it must not borrow the English one
+	msg, err = templates.Render("welcome", "fr", map[string]string{"Name": "Jane"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.HTML != "" {
+		t.Errorf("HTML = %q, want none", msg.HTML)
+	}
+
+	if _, err := templates.Render("missing", "en", nil); err == nil {
+		t.Error("Render() of an unknown template succeeded")
+	}
+}
+
+func TestParseTemplatesRequiresASubject(t *testing.T) {
+	_, err := ParseTemplates(fstest.MapFS{"en/welcome.txt": {Data: []byte("Hello")}}, "en")
+	if err == nil || !strings.Contains(err.Error(), "subject") {
+		t.Errorf("ParseTemplates() error = %v, want a missing subject error", err)
+	}
+}
+
+// Every embedded template must exist in every locale, a missing one would silently fall back to English
+func TestEmbeddedTemplates(t *testing.T) {
+	templates, err := NewTemplates("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]interface{}{
+		"Name":             "Jane",
+		"Link":             "https://example.com/?token=t",
+		"ExpiresInHours":   24,
+		"ExpiresInMinutes": 15,
+	}
+	names, err := fs.Glob(templatesFS, "templates/en/*.txt")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no embedded templates: %v", err)
+	}
+	for _, file := range names {
+		name := strings.TrimSuffix(file[len("templates/en/"):], ".txt")
+		for _, locale := range []string{"en", "fr"} {
+			if _, ok := templates.text[locale+"/"+name]; !ok {
+				t.Errorf("template %s has no %s variant", name, locale)
+			}
+			if _, err := templates.Render(name, locale, data); err != nil {
+				t.Errorf("Render(%s, %s) error = %v", name, locale, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"backend/pkg/response"
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// AuthMethod tells how the principal of a request was authenticated
+type AuthMethod string
+
+const (
+	AuthMethodSession AuthMethod = "session"
+	AuthMethodToken   AuthMethod = "token"
+)
+
+// Principal is the authenticated user behind a request
+type Principal struct {
+	UserID    uuid.UUID
+	Email     string
+	Role      string
+	Method    AuthMethod
+	SessionID string // Empty unless Method is AuthMethodSession
+}
+
+// SetPrincipal stores the authenticated user in the request locals
+func SetPrincipal(c *fiber.Ctx, principal *Principal) {
+	c.Locals("principal", principal)
+}
+
+// GetPrincipal returns the authenticated user of the request, if any
+func GetPrincipal(c *fiber.Ctx) (*Principal, bool) {
+	principal, ok := c.Locals("principal").(*Principal)
+	return principal, ok && principal != nil
+}
+
+// RequireAuth rejects anonymous requests with a 401, carrying a reason code when the session just expired
+func RequireAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := GetPrincipal(c); !ok {
+			if reason, ok := c.Locals("session_expired").(string); ok {
+				return response.ErrorWithCode(c, fiber.StatusUnauthorized, reason, "session expired, please sign in again")
+			}
+			return fiber.NewError(fiber.StatusUnauthorized, "authentication required")
+		}
+		return c.Next()
+	}
+}
+
+// RequireVerifiedEmail rejects users who haven't verified their email yet.
+// The status is looked up on every request so a verification applies to existing sessions and tokens.
+func RequireVerifiedEmail(isVerified func(ctx context.Context, userID uuid.UUID) (bool, error)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			return fiber.NewError(fiber.StatusUnauthorized, "authentication required")
+		}
+
+		verified, err := isVerified(c.Context(), principal.UserID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, "authentication required")
+		}
+		if !verified {
+			return fiber.NewError(fiber.StatusForbidden, "email verification required")
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"backend/pkg/response"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// LimitFailuresPerUser rejects the requests of a user who failed max of them within window,
+// for endpoints that check a secret the login protection doesn't cover, e.g. the current password.
+// The failures are counted in storage, the Redis shared by the instances, so they add up across them.
+func LimitFailuresPerUser(storage fiber.Storage, max int, window time.Duration) fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:                    max,
+		Expiration:             window,
+		SkipSuccessfulRequests: true,
+		LimiterMiddleware:      limiter.SlidingWindow{},
+		Storage:                storage,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			// Namespaced by route, the storage also holds the sessions
+			key := "failures:" + c.Route().Path + ":"
+			if principal, ok := GetPrincipal(c); ok {
+				return key + principal.UserID.String()
+			}
+			return key + c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return response.Error(c, fiber.StatusTooManyRequests, "Too many failed attempts, please try again later")
+		},
+	})
+}
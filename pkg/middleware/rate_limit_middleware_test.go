@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/storage/redis"
+	"github.com/google/uuid"
+)
+
+func newTestStorage(t *testing.T) *redis.Storage {
+	t.Helper()
+	server := miniredis.RunT(t)
+	port, err := strconv.Atoi(server.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := redis.New(redis.Config{Host: server.Host(), Port: port})
+	t.Cleanup(func() { _ = storage.Close() })
+	return storage
+}
+
+// newLimitedApp stands for one instance of the API, ?ok=true makes the request succeed
+func newLimitedApp(storage *redis.Storage) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		SetPrincipal(c, &Principal{UserID: uuid.MustParse(c.Get("X-User"))})
+		return c.Next()
+	})
+	app.Put("/password", LimitFailuresPerUser(storage, 2, time.Minute), func(c *fiber.Ctx) error {
+		if c.Query("ok") == "true" {
+			return c.SendStatus(fiber.StatusOK)
+		}
+		return c.SendStatus(fiber.StatusUnauthorized)
+	})
+	return app
+}
+
+func TestLimitFailuresPerUser(t *testing.T) {
+	storage := newTestStorage(t)
+	app, otherInstance := newLimitedApp(storage), newLimitedApp(storage)
+
+	send := func(app *fiber.App, user uuid.UUID, ok bool) int {
+		req := httptest.NewRequest(fiber.MethodPut, "/password?ok="+strconv.FormatBool(ok), nil)
+		req.Header.Set("X-User", user.String())
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	attacker, other := uuid.New(), uuid.New()
+	for i := 0; i < 3; i++ {
+		if got := send(app, attacker, true); got != fiber.StatusOK {
+			t.Fatalf("successful request %d = %d, successes must not count", i, got)
+		}
+	}
+	if got := send(app, attacker, false); got != fiber.StatusUnauthorized {
+		t.Fatalf("first failed request = %d, want 401", got)
+	}
+	if got := send(otherInstance, attacker, false); got != fiber.StatusUnauthorized {
+		t.Fatalf("second failed request = %d, want 401", got)
+	}
+	if got := send(app, attacker, false); got != fiber.StatusTooManyRequests {
+		t.Errorf("request past the limit = %d, want 429, the failures add up across instances", got)
+	}
+	if got := send(app, other, false); got != fiber.StatusUnauthorized {
+		t.Errorf("request of another user = %d, want 401", got)
+	}
+}
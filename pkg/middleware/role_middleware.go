@@ -6,12 +6,15 @@ import (
 
 func RequireRole(roles ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		userRole := c.Locals("role").(string)
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			return fiber.NewError(fiber.StatusUnauthorized, "authentication required")
+		}
 		for _, role := range roles {
-			if userRole == role {
+			if principal.Role == role {
 				return c.Next()
 			}
 		}
 		return fiber.NewError(fiber.StatusForbidden, "insufficient permissions")
 	}
-}
\ No newline at end of file
+}
@@ -2,31 +2,83 @@ package middleware
 
 import (
 	"backend/pkg/response"
+	"backend/pkg/sessions"
+	"log"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/google/uuid"
 )
 
-func HandleSession(store *session.Store) fiber.Handler {
+// Reason codes of the 401 returned once a session expired
+const (
+	SessionIdleTimeout = "session_idle_timeout"
+	SessionExpired     = "session_expired"
+)
+
+// ExpiredSessionGrace keeps an expired session in the store long enough to tell the client why it was signed out
+const ExpiredSessionGrace = time.Hour
+
+func HandleSession(store *session.Store, registry *sessions.Registry) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get or create session
 		sess, err := store.Get(c)
 		if err != nil {
 			return response.Error(c, fiber.StatusInternalServerError, "Session error")
 		}
+		c.Locals("store", store)
+
+		// Anonymous sessions are only saved by the handlers that put something in them
+		rawUserID, ok := sess.Get("user_id").(string)
+		if !ok {
+			return c.Next()
+		}
+		userID, err := uuid.Parse(rawUserID)
+		if err != nil {
+			return c.Next()
+		}
 
-		// Update session activity
-		sess.Set("last_activity", time.Now().Unix())
+		now := time.Now()
+		if reason := expiredReason(sess, now); reason != "" {
+			if err := registry.Untrack(c.Context(), userID, sess.ID()); err != nil {
+				log.Printf("Failed to untrack session: %v", err)
+			}
+			if err := sess.Destroy(); err != nil {
+				return response.Error(c, fiber.StatusInternalServerError, "Failed to destroy session")
+			}
+			// Routes behind RequireAuth answer with the reason, public ones carry on anonymously
+			c.Locals("session_expired", reason)
+			return c.Next()
+		}
 
-		// Set session data to locals
-		c.Locals("store", store)
+		// Update session activity, a "remember me" session slides its expiry along
+		sess.Set("last_activity", now.Unix())
+		if rememberMe, _ := sess.Get("remember_me").(bool); rememberMe {
+			idleTimeout, _ := sess.Get("idle_timeout").(int64)
+			sess.Set("expires_at", now.Add(time.Duration(idleTimeout)*time.Second).Unix())
+		}
+		expiresAt, _ := sess.Get("expires_at").(int64)
+		ttl := time.Until(time.Unix(expiresAt, 0)) + ExpiredSessionGrace
+		sess.SetExpiry(ttl)
+
+		// The user is only exposed through the principal, the locals keep the session timing
 		c.Locals("last_activity", sess.Get("last_activity"))
-		if sess.Get("user_id") != nil {
-			c.Locals("user_id", sess.Get("user_id"))
-			c.Locals("email", sess.Get("email"))
-			c.Locals("role", sess.Get("role"))
-			c.Locals("expires_at", sess.Get("expires_at"))
+		c.Locals("expires_at", sess.Get("expires_at"))
+
+		email, _ := sess.Get("email").(string)
+		role, _ := sess.Get("role").(string)
+		SetPrincipal(c, &Principal{
+			UserID:    userID,
+			Email:     email,
+			Role:      role,
+			Method:    AuthMethodSession,
+			SessionID: sess.ID(),
+		})
+
+		// Keeps the session list of the user up to date, not worth failing the request for
+		if err := registry.Touch(c.Context(), userID, sess.ID(), c.IP(), ttl); err != nil {
+			log.Printf("Failed to record session activity: %v", err)
 		}
 
 		if err := sess.Save(); err != nil {
@@ -36,3 +88,19 @@ func HandleSession(store *session.Store) fiber.Handler {
 		return c.Next()
 	}
 }
+
+// expiredReason returns the reason code of an expired session, empty while it is valid
+func expiredReason(sess *session.Session, now time.Time) string {
+	lastActivity, _ := sess.Get("last_activity").(int64)
+	idleTimeout, _ := sess.Get("idle_timeout").(int64)
+	if idleTimeout > 0 && now.Unix()-lastActivity > idleTimeout {
+		return SessionIdleTimeout
+	}
+
+	expiresAt, _ := sess.Get("expires_at").(int64)
+	if expiresAt > 0 && now.Unix() > expiresAt {
+		return SessionExpired
+	}
+
+	return ""
+}
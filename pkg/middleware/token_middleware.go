@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"backend/pkg/response"
+	"backend/pkg/utils"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// HandleBearerToken authenticates requests carrying an access JWT in the Authorization header.
+// Requests without a bearer token are left to the session middleware, it runs after it and rejects
+// the requests of a signed in session that also carry a token rather than pick one of the two users.
+func HandleBearerToken(secret string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header := c.Get(fiber.HeaderAuthorization)
+		if header == "" {
+			return c.Next()
+		}
+
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return c.Next()
+		}
+
+		if _, ok := GetPrincipal(c); ok {
+			return response.Error(c, fiber.StatusBadRequest, "Send either a session cookie or a bearer token, not both")
+		}
+
+		claims, err := utils.ParseAccessToken(secret, token)
+		if err != nil {
+			return response.Error(c, fiber.StatusUnauthorized, "Invalid or expired access token")
+		}
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			return response.Error(c, fiber.StatusUnauthorized, "Invalid or expired access token")
+		}
+
+		c.Locals("expires_at", claims.ExpiresAt.Unix())
+		SetPrincipal(c, &Principal{
+			UserID: userID,
+			Email:  claims.Email,
+			Role:   claims.Role,
+			Method: AuthMethodToken,
+		})
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/pkg/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const testSecret = "secret"
+
+// newTokenApp answers with the method of the principal, X-Session stands for a signed in session
+func newTokenApp() *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if c.Get("X-Session") != "" {
+			SetPrincipal(c, &Principal{UserID: uuid.New(), Method: AuthMethodSession})
+		}
+		return c.Next()
+	})
+	app.Use(HandleBearerToken(testSecret))
+	app.Get("/", func(c *fiber.Ctx) error {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			return c.SendString("anonymous")
+		}
+		return c.SendString(string(principal.Method))
+	})
+	return app
+}
+
+func TestHandleBearerToken(t *testing.T) {
+	token, err := utils.GenerateAccessToken(testSecret, uuid.New(), "jane@example.com", "user", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		session       bool
+		wantStatus    int
+		wantBody      string
+	}{
+		{name: "no token", wantStatus: fiber.StatusOK, wantBody: "anonymous"},
+		{name: "valid token", authorization: "Bearer " + token, wantStatus: fiber.StatusOK, wantBody: "token"},
+		{name: "invalid token", authorization: "Bearer nope", wantStatus: fiber.StatusUnauthorized},
+		{name: "session only", session: true, wantStatus: fiber.StatusOK, wantBody: "session"},
+		{name: "session and token", authorization: "Bearer " + token, session: true, wantStatus: fiber.StatusBadRequest},
+	}
+
+	app := newTokenApp()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.authorization)
+			}
+			if tt.session {
+				req.Header.Set("X-Session", "1")
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(body); got != tt.wantBody {
+				t.Errorf("principal = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
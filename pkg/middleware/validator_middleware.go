@@ -1,20 +1,71 @@
 package middleware
 
 import (
+	"backend/pkg/response"
+	"backend/pkg/security"
 	"fmt"
+	"reflect"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 )
 
-var validate = validator.New()
+var validate = newValidator()
+
+// passwordPolicy backs the "password" tag, every password is accepted until SetPasswordPolicy is called
+var passwordPolicy *security.PasswordPolicy
 
 type ErrorResponse struct {
 	Field   string `json:"field"`
 	Message string `json:"message"`
 }
 
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// Errors are reported with the names the client sent
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return strings.ToLower(field.Name)
+		}
+		return name
+	})
+
+	_ = v.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		if passwordPolicy == nil {
+			return true
+		}
+		return len(passwordPolicy.Check(fl.Field().String(), personalInfo(fl.Parent())...)) == 0
+	})
+
+	return v
+}
+
+// SetPasswordPolicy sets the rules checked by the "password" tag
+func SetPasswordPolicy(policy *security.PasswordPolicy) {
+	passwordPolicy = policy
+}
+
+// personalInfo returns the Email and Name fields of a payload, a password must not contain them
+func personalInfo(v reflect.Value) []string {
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var values []string
+	for _, name := range []string{"Email", "Name"} {
+		if field := v.FieldByName(name); field.IsValid() && field.Kind() == reflect.String && field.String() != "" {
+			values = append(values, field.String())
+		}
+	}
+	return values
+}
+
 func getErrorMessage(fe validator.FieldError) string {
 	switch fe.Tag() {
 	case "required":
@@ -25,23 +76,63 @@ func getErrorMessage(fe validator.FieldError) string {
 		return fmt.Sprintf("Minimum length is %s", fe.Param())
 	case "max":
 		return fmt.Sprintf("Maximum length is %s", fe.Param())
+	case "bcp47_language_tag":
+		return "Invalid language tag"
+	case "password":
+		return "Password does not meet the policy"
 	}
 	return fe.Error() // default error
 }
 
+// FieldErrors reports each message on the same field
+func FieldErrors(field string, messages []string) []ErrorResponse {
+	errs := make([]ErrorResponse, 0, len(messages))
+	for _, message := range messages {
+		errs = append(errs, ErrorResponse{Field: field, Message: message})
+	}
+	return errs
+}
+
+// ValidationError responds 400 with the field errors in data, the message sums them up for older clients
+func ValidationError(c *fiber.Ctx, errs []ErrorResponse) error {
+	errorMsgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		errorMsgs = append(errorMsgs, fmt.Sprintf("%s: %s", err.Field, err.Message))
+	}
+
+	return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+		Success: false,
+		Message: strings.Join(errorMsgs, "; "),
+		Data:    errs,
+	})
+}
+
+// ValidateRequest parses the body into a new value of the type of payload and validates it.
+// The handler finds it in the "payload" local.
 func ValidateRequest(payload interface{}) fiber.Handler {
+	payloadType := reflect.TypeOf(payload).Elem()
+
 	return func(c *fiber.Ctx) error {
+		// A value per request, a shared one would leak fields between concurrent requests
+		payload := reflect.New(payloadType).Interface()
+
 		if err := c.BodyParser(payload); err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
 		}
 
 		if err := validate.Struct(payload); err != nil {
-			var errorMsgs []string
+			var errs []ErrorResponse
 			for _, err := range err.(validator.ValidationErrors) {
-				field := strings.ToLower(err.Field())
-				errorMsgs = append(errorMsgs, fmt.Sprintf("%s: %s", field, getErrorMessage(err)))
+				if err.Tag() == "password" && passwordPolicy != nil {
+					violations := passwordPolicy.Check(err.Value().(string), personalInfo(reflect.ValueOf(payload))...)
+					if len(violations) > 0 {
+						errs = append(errs, FieldErrors(err.Field(), violations)...)
+						continue
+					}
+				}
+				errs = append(errs, ErrorResponse{Field: err.Field(), Message: getErrorMessage(err)})
 			}
-			return fiber.NewError(fiber.StatusBadRequest, strings.Join(errorMsgs, "; "))
+			return ValidationError(c, errs)
 		}
 
 		c.Locals("payload", payload)
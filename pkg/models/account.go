@@ -6,8 +6,15 @@ import (
 	"github.com/google/uuid"
 )
 
+// Types of Account, each one is a way for the user to sign in
+const (
+	AccountTypeCredentials = "credentials"
+	AccountTypeOAuth       = "oauth"
+	AccountTypeWebAuthn    = "webauthn"
+)
+
 // Account stores OAuth account information for users
-// One user can have multiple accounts (e.g., sign in with Google AND Discord)
+// One user can have multiple accounts (e.g., sign in with Google AND Discord, or several passkeys)
 // No validate keyword is used here because the creation of accounts is handled by the service layer
 type Account struct {
 	BaseModel
@@ -15,15 +22,26 @@ type Account struct {
 	User   User      `json:"-" gorm:"foreignKey:UserID"`
 	Type   string    `json:"type" gorm:"not null;default:'credentials'"`
 
-	// Credentials-specific
-	Password string `json:"password,omitempty"`
+	// Credentials-specific, never serialized
+	Password string `json:"-"`
 
-	// OAuth-specific
+	// OAuth-specific, provider tokens are encrypted at rest and never serialized
 	Provider          string    `json:"provider,omitempty"`
 	ProviderAccountID string    `json:"provider_account_id,omitempty"`
-	RefreshToken      string    `json:"refresh_token,omitempty"`
-	AccessToken       string    `json:"access_token,omitempty"`
-	ExpiresAt         time.Time `json:"expires_at,omitempty"`
+	RefreshToken      string    `json:"-" gorm:"serializer:encrypted"`
+	AccessToken       string    `json:"-" gorm:"serializer:encrypted"`
+	ExpiresAt         time.Time `json:"expires_at,omitempty"` // Expiry of AccessToken, zero when it doesn't expire
 	TokenType         string    `json:"token_type,omitempty"`
 	Scope             string    `json:"scope,omitempty"`
+
+	// WebAuthn-specific, one account per passkey. Provider is "webauthn" and
+	// ProviderAccountID holds the base64url encoded credential ID.
+	Name            string     `json:"name,omitempty"` // Label chosen by the user, e.g. "MacBook"
+	PublicKey       []byte     `json:"-"`              // COSE encoded credential public key
+	SignCount       int64      `json:"-"`
+	Transports      string     `json:"-"` // Comma separated, e.g. "internal,hybrid"
+	AttestationType string     `json:"-"`
+	BackupEligible  bool       `json:"-"`
+	BackupState     bool       `json:"-"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
 }
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purposes of a OneTimeToken, a token is only accepted by the flow it was issued for
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeMagicLink         = "magic_link"
+)
+
+// OneTimeToken is a short-lived single use token sent to a user by email
+// Only the SHA-256 hash of the token is stored
+type OneTimeToken struct {
+	BaseModel
+	UserID    *uuid.UUID `json:"user_id" gorm:"index"` // Nil for a sign up magic link sent to an unknown email
+	User      User       `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Purpose   string     `json:"purpose" gorm:"not null;index"`
+	Email     string     `json:"email" gorm:"not null"` // Address the token was sent to
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
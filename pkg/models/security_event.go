@@ -0,0 +1,20 @@
+package models
+
+import "github.com/google/uuid"
+
+// Types of SecurityEvent
+const (
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventAccountUnlocked = "account_unlocked"
+	SecurityEventIPLocked        = "ip_locked"
+)
+
+// SecurityEvent is an audit record of something that happened to the security of an account
+type SecurityEvent struct {
+	BaseModel
+	UserID  *uuid.UUID `json:"user_id" gorm:"index"` // Nil when the email matches no user
+	Type    string     `json:"type" gorm:"not null;index"`
+	Email   string     `json:"email"`
+	IP      string     `json:"ip"`
+	Details string     `json:"details"`
+}
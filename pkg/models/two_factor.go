@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TwoFactor is the TOTP authenticator of a user, two-factor authentication is on once EnabledAt is set
+type TwoFactor struct {
+	BaseModel
+	UserID       uuid.UUID  `json:"user_id" gorm:"not null;uniqueIndex"`
+	User         User       `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Secret       string     `json:"-" gorm:"not null;serializer:encrypted"` // Base32 secret, encrypted at rest
+	EnabledAt    *time.Time `json:"enabled_at,omitempty"`
+	LastUsedStep int64      `json:"-"` // Time step of the last accepted code, older steps are replays
+}
+
+// IsEnabled reports whether the enrollment was confirmed with a valid code
+func (t *TwoFactor) IsEnabled() bool {
+	return t.EnabledAt != nil
+}
+
+// RecoveryCode is a single use code that replaces a TOTP code when the authenticator is lost
+// Only the SHA-256 hash of the code is stored
+type RecoveryCode struct {
+	BaseModel
+	UserID   uuid.UUID  `json:"user_id" gorm:"not null;index"`
+	User     User       `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	CodeHash string     `json:"-" gorm:"not null;index"`
+	UsedAt   *time.Time `json:"used_at,omitempty"`
+}
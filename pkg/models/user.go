@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // User model gather every information about a user
 type User struct {
 	BaseModel
@@ -8,5 +10,20 @@ type User struct {
 	Image    string    `json:"image"`
 	Role     string    `json:"role" validate:"required,oneof=admin user" gorm:"not null;default:'user';index"`
 	Phone    string    `json:"phone"`
+	Locale   string    `json:"locale"` // Language of the emails sent to the user, e.g. "fr"
 	Accounts []Account `json:"accounts" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+
+	// Set once the user proved they own Email, nil while unverified
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+
+	// Overwrite Name and Image with the provider profile on each OAuth sign in, otherwise only empty fields are filled
+	SyncProfile bool `json:"sync_profile" gorm:"not null;default:false"`
+
+	// Ask a password login for a passkey as second factor, passkeys alone only enable the passwordless login
+	PasskeySecondFactor bool `json:"passkey_second_factor" gorm:"not null;default:false"`
+}
+
+// IsEmailVerified reports whether the user proved they own their email address
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
 }
@@ -0,0 +1,67 @@
+package oauth
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/utils"
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+var DiscordEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://discord.com/api/oauth2/authorize",
+	TokenURL: "https://discord.com/api/oauth2/token",
+}
+
+func init() {
+	Register("discord", NewDiscordProvider)
+}
+
+type discordProvider struct {
+	baseProvider
+	userInfoURL string
+}
+
+func NewDiscordProvider(cfg config.OAuthConfig) (Provider, error) {
+	return &discordProvider{
+		baseProvider: baseProvider{
+			cfg:           cfg,
+			endpoint:      DiscordEndpoint,
+			defaultScopes: []string{"identify", "email"},
+		},
+		userInfoURL: "https://discord.com/api/users/@me",
+	}, nil
+}
+
+func (p *discordProvider) FetchUserInfo(ctx context.Context, token *oauth2.Token) (*utils.UserInfo, error) {
+	var result struct {
+		ID            string `json:"id"`
+		Username      string `json:"username"`
+		Discriminator string `json:"discriminator"`
+		Avatar        string `json:"avatar"`
+		Email         string `json:"email"`
+		Verified      bool   `json:"verified"`
+	}
+
+	if err := getJSON(ctx, p.userInfoURL, token.AccessToken, &result); err != nil {
+		return nil, err
+	}
+
+	// Users without an avatar have none to link to
+	var image string
+	if result.Avatar != "" {
+		image = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", result.ID, result.Avatar)
+	}
+
+	return &utils.UserInfo{
+		ID:                result.ID,
+		Name:              result.Username, // + "#" + result.Discriminator,
+		Email:             result.Email,
+		EmailVerified:     result.Verified,
+		Image:             image,
+		Provider:          p.Name(),
+		ProviderAccountID: result.ID,
+		AccessToken:       token.AccessToken,
+	}, nil
+}
@@ -0,0 +1,44 @@
+package oauth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDiscordFetchUserInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		avatar string
+		image  string
+	}{
+		{"with avatar", "a1b2c3", "https://cdn.discordapp.com/avatars/80351110224678912/a1b2c3.png"},
+		{"without avatar", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := stubAPI(t, map[string]interface{}{
+				"/users/@me": map[string]interface{}{
+					"id":       "80351110224678912",
+					"username": "nelly",
+					"avatar":   tt.avatar,
+					"email":    "nelly@example.com",
+					"verified": true,
+				},
+			})
+			provider, _ := NewDiscordProvider(testConfig("discord", nil))
+			provider.(*discordProvider).userInfoURL = server.URL + "/users/@me"
+
+			userInfo, err := provider.FetchUserInfo(context.Background(), testToken)
+			if err != nil {
+				t.Fatalf("FetchUserInfo: %v", err)
+			}
+			if userInfo.Image != tt.image {
+				t.Errorf("Image = %q, want %q", userInfo.Image, tt.image)
+			}
+			if !userInfo.EmailVerified || userInfo.Email != "nelly@example.com" {
+				t.Errorf("email = %q verified = %v", userInfo.Email, userInfo.EmailVerified)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package oauth
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/utils"
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	Register("github", NewGitHubProvider)
+}
+
+type githubProvider struct {
+	baseProvider
+	apiURL string
+}
+
+func NewGitHubProvider(cfg config.OAuthConfig) (Provider, error) {
+	return newGitHubProvider(cfg, "https://github.com", "https://api.github.com"), nil
+}
+
+// newGitHubProvider builds the provider against the given web and API base URLs
+func newGitHubProvider(cfg config.OAuthConfig, webURL, apiURL string) *githubProvider {
+	return &githubProvider{
+		baseProvider: baseProvider{
+			cfg: cfg,
+			endpoint: oauth2.Endpoint{
+				AuthURL:  webURL + "/login/oauth/authorize",
+				TokenURL: webURL + "/login/oauth/access_token",
+			},
+			defaultScopes: []string{"read:user", "user:email"},
+		},
+		apiURL: apiURL,
+	}
+}
+
+// FetchUserInfo reads the profile, then the email addresses since the profile only has the public one, if any
+func (p *githubProvider) FetchUserInfo(ctx context.Context, token *oauth2.Token) (*utils.UserInfo, error) {
+	var result struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+
+	if err := getJSON(ctx, p.apiURL+"/user", token.AccessToken, &result); err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	if err := getJSON(ctx, p.apiURL+"/user/emails", token.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	// The primary address is the one GitHub mails, an unverified one could belong to anybody
+	var email string
+	for _, candidate := range emails {
+		if candidate.Primary && candidate.Verified {
+			email = candidate.Email
+			break
+		}
+	}
+	if email == "" {
+		return nil, fmt.Errorf("%w: no verified primary email on GitHub", ErrEmailUnavailable)
+	}
+
+	name := result.Name
+	if name == "" {
+		name = result.Login
+	}
+
+	return &utils.UserInfo{
+		ID:                result.ID,
+		Name:              name,
+		Email:             email,
+		EmailVerified:     true,
+		Image:             result.AvatarURL,
+		Provider:          p.Name(),
+		ProviderAccountID: fmt.Sprint(result.ID),
+		AccessToken:       token.AccessToken,
+	}, nil
+}
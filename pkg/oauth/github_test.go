@@ -0,0 +1,81 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGitHubFetchUserInfo(t *testing.T) {
+	server := stubAPI(t, map[string]interface{}{
+		"/user": map[string]interface{}{
+			"id":         1234,
+			"login":      "octocat",
+			"name":       "",
+			"avatar_url": "https://avatars.githubusercontent.com/u/1234",
+		},
+		"/user/emails": []map[string]interface{}{
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "octocat@example.com", "primary": true, "verified": true},
+		},
+	})
+	provider := newGitHubProvider(testConfig("github", nil), server.URL, server.URL)
+
+	userInfo, err := provider.FetchUserInfo(context.Background(), testToken)
+	if err != nil {
+		t.Fatalf("FetchUserInfo: %v", err)
+	}
+	if userInfo.Email != "octocat@example.com" || !userInfo.EmailVerified {
+		t.Errorf("email = %q verified = %v, want the primary verified address", userInfo.Email, userInfo.EmailVerified)
+	}
+	if userInfo.Name != "octocat" {
+		t.Errorf("Name = %q, want the login when the name is empty", userInfo.Name)
+	}
+	if userInfo.ProviderAccountID != "1234" {
+		t.Errorf("ProviderAccountID = %q, want 1234", userInfo.ProviderAccountID)
+	}
+	if userInfo.Image != "https://avatars.githubusercontent.com/u/1234" {
+		t.Errorf("Image = %q", userInfo.Image)
+	}
+}
+
+func TestGitHubFetchUserInfoRequiresVerifiedPrimaryEmail(t *testing.T) {
+	server := stubAPI(t, map[string]interface{}{
+		"/user": map[string]interface{}{"id": 1234, "login": "octocat"},
+		"/user/emails": []map[string]interface{}{
+			{"email": "octocat@example.com", "primary": true, "verified": false},
+			{"email": "other@example.com", "primary": false, "verified": true},
+		},
+	})
+	provider := newGitHubProvider(testConfig("github", nil), server.URL, server.URL)
+
+	_, err := provider.FetchUserInfo(context.Background(), testToken)
+	if !errors.Is(err, ErrEmailUnavailable) {
+		t.Fatalf("err = %v, want ErrEmailUnavailable", err)
+	}
+}
+
+func TestGitHubFetchUserInfoWithoutAvatar(t *testing.T) {
+	server := stubAPI(t, map[string]interface{}{
+		"/user": map[string]interface{}{"id": 1234, "login": "octocat", "name": "The Octocat", "avatar_url": ""},
+		"/user/emails": []map[string]interface{}{
+			{"email": "octocat@example.com", "primary": true, "verified": true},
+		},
+	})
+	provider := newGitHubProvider(testConfig("github", nil), server.URL, server.URL)
+
+	userInfo, err := provider.FetchUserInfo(context.Background(), testToken)
+	if err != nil {
+		t.Fatalf("FetchUserInfo: %v", err)
+	}
+	if userInfo.Image != "" {
+		t.Errorf("Image = %q, want empty", userInfo.Image)
+	}
+}
+
+func TestGitHubEndpoints(t *testing.T) {
+	provider := newGitHubProvider(testConfig("github", nil), "https://github.example.com", "https://api.github.example.com")
+	if got, want := provider.Endpoint().TokenURL, "https://github.example.com/login/oauth/access_token"; got != want {
+		t.Errorf("TokenURL = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package oauth
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/utils"
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	Register("gitlab", NewGitLabProvider, "BASE_URL")
+}
+
+type gitlabProvider struct {
+	baseProvider
+	userInfoURL string
+}
+
+// NewGitLabProvider builds a provider for gitlab.com or the self-hosted instance at GITLAB_BASE_URL
+func NewGitLabProvider(cfg config.OAuthConfig) (Provider, error) {
+	baseURL := strings.TrimSuffix(setting(cfg, "BASE_URL", "https://gitlab.com"), "/")
+
+	return &gitlabProvider{
+		baseProvider: baseProvider{
+			cfg: cfg,
+			endpoint: oauth2.Endpoint{
+				AuthURL:  baseURL + "/oauth/authorize",
+				TokenURL: baseURL + "/oauth/token",
+			},
+			defaultScopes: []string{"read_user"},
+		},
+		userInfoURL: baseURL + "/api/v4/user",
+	}, nil
+}
+
+func (p *gitlabProvider) FetchUserInfo(ctx context.Context, token *oauth2.Token) (*utils.UserInfo, error) {
+	var result struct {
+		ID          int64   `json:"id"`
+		Username    string  `json:"username"`
+		Name        string  `json:"name"`
+		Email       string  `json:"email"`
+		AvatarURL   string  `json:"avatar_url"`
+		ConfirmedAt *string `json:"confirmed_at"`
+	}
+
+	if err := getJSON(ctx, p.userInfoURL, token.AccessToken, &result); err != nil {
+		return nil, err
+	}
+
+	name := result.Name
+	if name == "" {
+		name = result.Username
+	}
+
+	return &utils.UserInfo{
+		ID:    result.ID,
+		Name:  name,
+		Email: result.Email,
+		// GitLab only makes a confirmed address primary, an unconfirmed user still has one
+		EmailVerified:     result.ConfirmedAt != nil,
+		Image:             result.AvatarURL,
+		Provider:          p.Name(),
+		ProviderAccountID: fmt.Sprint(result.ID),
+		AccessToken:       token.AccessToken,
+	}, nil
+}
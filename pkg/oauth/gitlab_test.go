@@ -0,0 +1,70 @@
+package oauth
+
+import (
+	"context"
+	"testing"
+)
+
+func newTestGitLabProvider(t *testing.T, user map[string]interface{}) Provider {
+	t.Helper()
+
+	// Self-hosted instances may live under a path, the trailing slash is tolerated
+	server := stubAPI(t, map[string]interface{}{"/gitlab/api/v4/user": user})
+	provider, err := NewGitLabProvider(testConfig("gitlab", map[string]string{"BASE_URL": server.URL + "/gitlab/"}))
+	if err != nil {
+		t.Fatalf("NewGitLabProvider: %v", err)
+	}
+	if got, want := provider.Endpoint().AuthURL, server.URL+"/gitlab/oauth/authorize"; got != want {
+		t.Errorf("AuthURL = %q, want %q", got, want)
+	}
+	return provider
+}
+
+func TestGitLabFetchUserInfo(t *testing.T) {
+	provider := newTestGitLabProvider(t, map[string]interface{}{
+		"id":           42,
+		"username":     "jdoe",
+		"name":         "Jane Doe",
+		"email":        "jane@example.com",
+		"avatar_url":   "https://gitlab.example.com/uploads/avatar.png",
+		"confirmed_at": "2024-01-01T00:00:00Z",
+	})
+
+	userInfo, err := provider.FetchUserInfo(context.Background(), testToken)
+	if err != nil {
+		t.Fatalf("FetchUserInfo: %v", err)
+	}
+	if userInfo.Email != "jane@example.com" || !userInfo.EmailVerified {
+		t.Errorf("email = %q verified = %v, want a confirmed address", userInfo.Email, userInfo.EmailVerified)
+	}
+	if userInfo.Name != "Jane Doe" || userInfo.ProviderAccountID != "42" {
+		t.Errorf("Name = %q ProviderAccountID = %q", userInfo.Name, userInfo.ProviderAccountID)
+	}
+	if userInfo.Image != "https://gitlab.example.com/uploads/avatar.png" {
+		t.Errorf("Image = %q", userInfo.Image)
+	}
+}
+
+func TestGitLabFetchUserInfoUnconfirmedWithoutAvatar(t *testing.T) {
+	provider := newTestGitLabProvider(t, map[string]interface{}{
+		"id":           42,
+		"username":     "jdoe",
+		"email":        "jane@example.com",
+		"avatar_url":   nil,
+		"confirmed_at": nil,
+	})
+
+	userInfo, err := provider.FetchUserInfo(context.Background(), testToken)
+	if err != nil {
+		t.Fatalf("FetchUserInfo: %v", err)
+	}
+	if userInfo.EmailVerified {
+		t.Error("EmailVerified = true for an unconfirmed user")
+	}
+	if userInfo.Name != "jdoe" {
+		t.Errorf("Name = %q, want the username when the name is empty", userInfo.Name)
+	}
+	if userInfo.Image != "" {
+		t.Errorf("Image = %q, want empty", userInfo.Image)
+	}
+}
@@ -0,0 +1,65 @@
+package oauth
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/utils"
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+var GoogleEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://accounts.google.com/o/oauth2/auth",
+	TokenURL: "https://oauth2.googleapis.com/token",
+}
+
+func init() {
+	Register("google", NewGoogleProvider)
+}
+
+type googleProvider struct {
+	baseProvider
+	userInfoURL string
+}
+
+func NewGoogleProvider(cfg config.OAuthConfig) (Provider, error) {
+	return &googleProvider{
+		baseProvider: baseProvider{
+			cfg:           cfg,
+			endpoint:      GoogleEndpoint,
+			defaultScopes: []string{"profile", "email"},
+		},
+		userInfoURL: "https://www.googleapis.com/oauth2/v2/userinfo",
+	}, nil
+}
+
+// AuthCodeOptions asks for offline access, Google only issues a refresh token with it
+func (p *googleProvider) AuthCodeOptions() []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+}
+
+func (p *googleProvider) FetchUserInfo(ctx context.Context, token *oauth2.Token) (*utils.UserInfo, error) {
+	var result struct {
+		ID            interface{} `json:"id"`
+		Email         string      `json:"email"`
+		Name          string      `json:"name"`
+		Picture       string      `json:"picture"`
+		VerifiedEmail bool        `json:"verified_email"`
+	}
+
+	if err := getJSON(ctx, p.userInfoURL, token.AccessToken, &result); err != nil {
+		return nil, err
+	}
+
+	return &utils.UserInfo{
+		ID:                result.ID,
+		Name:              result.Name,
+		Email:             result.Email,
+		Image:             result.Picture,
+		EmailVerified:     result.VerifiedEmail,
+		Provider:          p.Name(),
+		ProviderAccountID: fmt.Sprint(result.ID),
+		AccessToken:       token.AccessToken,
+	}, nil
+}
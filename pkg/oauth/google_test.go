@@ -0,0 +1,43 @@
+package oauth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGoogleFetchUserInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		picture string
+	}{
+		{"with picture", "https://lh3.googleusercontent.com/a/photo"},
+		{"without picture", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := stubAPI(t, map[string]interface{}{
+				"/oauth2/v2/userinfo": map[string]interface{}{
+					"id":             "108",
+					"email":          "jane@gmail.com",
+					"name":           "Jane Doe",
+					"picture":        tt.picture,
+					"verified_email": false,
+				},
+			})
+			provider, _ := NewGoogleProvider(testConfig("google", nil))
+			provider.(*googleProvider).userInfoURL = server.URL + "/oauth2/v2/userinfo"
+
+			userInfo, err := provider.FetchUserInfo(context.Background(), testToken)
+			if err != nil {
+				t.Fatalf("FetchUserInfo: %v", err)
+			}
+			if userInfo.Image != tt.picture {
+				t.Errorf("Image = %q, want %q", userInfo.Image, tt.picture)
+			}
+			if userInfo.EmailVerified {
+				t.Error("EmailVerified = true, want the verified_email flag")
+			}
+		})
+	}
+}
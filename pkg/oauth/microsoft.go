@@ -0,0 +1,73 @@
+package oauth
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/utils"
+	"context"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	Register("microsoft", NewMicrosoftProvider, "TENANT")
+}
+
+type microsoftProvider struct {
+	baseProvider
+	userInfoURL string
+}
+
+// NewMicrosoftProvider builds a Microsoft Entra ID provider for MICROSOFT_TENANT: common (the default),
+// organizations, consumers or a tenant ID
+func NewMicrosoftProvider(cfg config.OAuthConfig) (Provider, error) {
+	return newMicrosoftProvider(cfg, "https://login.microsoftonline.com", "https://graph.microsoft.com"), nil
+}
+
+// newMicrosoftProvider builds the provider against the given login and Graph base URLs
+func newMicrosoftProvider(cfg config.OAuthConfig, loginURL, graphURL string) *microsoftProvider {
+	tenant := setting(cfg, "TENANT", "common")
+	return &microsoftProvider{
+		baseProvider: baseProvider{
+			cfg: cfg,
+			endpoint: oauth2.Endpoint{
+				AuthURL:  loginURL + "/" + tenant + "/oauth2/v2.0/authorize",
+				TokenURL: loginURL + "/" + tenant + "/oauth2/v2.0/token",
+			},
+			defaultScopes: []string{"openid", "profile", "email", "User.Read"},
+		},
+		userInfoURL: graphURL + "/v1.0/me",
+	}
+}
+
+// FetchUserInfo reads the profile from Microsoft Graph.
+// The email is never reported as verified: tenant admins can set any address on their users.
+// There is no image either, Graph only serves the photo to authenticated requests.
+func (p *microsoftProvider) FetchUserInfo(ctx context.Context, token *oauth2.Token) (*utils.UserInfo, error) {
+	var result struct {
+		ID                string `json:"id"`
+		DisplayName       string `json:"displayName"`
+		Mail              string `json:"mail"`
+		UserPrincipalName string `json:"userPrincipalName"`
+	}
+
+	if err := getJSON(ctx, p.userInfoURL, token.AccessToken, &result); err != nil {
+		return nil, err
+	}
+
+	// Personal accounts have no mail, their principal name is their address
+	email := result.Mail
+	if email == "" && strings.Contains(result.UserPrincipalName, "@") {
+		email = result.UserPrincipalName
+	}
+
+	return &utils.UserInfo{
+		ID:                result.ID,
+		Name:              result.DisplayName,
+		Email:             email,
+		EmailVerified:     false,
+		Provider:          p.Name(),
+		ProviderAccountID: result.ID,
+		AccessToken:       token.AccessToken,
+	}, nil
+}
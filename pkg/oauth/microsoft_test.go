@@ -0,0 +1,60 @@
+package oauth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMicrosoftFetchUserInfo(t *testing.T) {
+	server := stubAPI(t, map[string]interface{}{
+		"/v1.0/me": map[string]interface{}{
+			"id":                "00000000-0000-0000-0000-000000000001",
+			"displayName":       "Jane Doe",
+			"mail":              "jane@contoso.com",
+			"userPrincipalName": "jane_contoso.com#EXT#@fabrikam.onmicrosoft.com",
+		},
+	})
+	provider := newMicrosoftProvider(testConfig("microsoft", map[string]string{"TENANT": "contoso"}), server.URL, server.URL)
+
+	if got, want := provider.Endpoint().AuthURL, server.URL+"/contoso/oauth2/v2.0/authorize"; got != want {
+		t.Errorf("AuthURL = %q, want %q", got, want)
+	}
+
+	userInfo, err := provider.FetchUserInfo(context.Background(), testToken)
+	if err != nil {
+		t.Fatalf("FetchUserInfo: %v", err)
+	}
+	if userInfo.Email != "jane@contoso.com" {
+		t.Errorf("Email = %q, want the mail", userInfo.Email)
+	}
+	if userInfo.EmailVerified {
+		t.Error("EmailVerified = true, Microsoft emails are never vouched for")
+	}
+	if userInfo.Image != "" {
+		t.Errorf("Image = %q, want empty", userInfo.Image)
+	}
+}
+
+func TestMicrosoftFetchUserInfoPersonalAccount(t *testing.T) {
+	server := stubAPI(t, map[string]interface{}{
+		"/v1.0/me": map[string]interface{}{
+			"id":                "0123456789abcdef",
+			"displayName":       "Jane Doe",
+			"mail":              nil,
+			"userPrincipalName": "jane@outlook.com",
+		},
+	})
+	provider := newMicrosoftProvider(testConfig("microsoft", nil), server.URL, server.URL)
+
+	if got, want := provider.Endpoint().TokenURL, server.URL+"/common/oauth2/v2.0/token"; got != want {
+		t.Errorf("TokenURL = %q, want %q", got, want)
+	}
+
+	userInfo, err := provider.FetchUserInfo(context.Background(), testToken)
+	if err != nil {
+		t.Fatalf("FetchUserInfo: %v", err)
+	}
+	if userInfo.Email != "jane@outlook.com" {
+		t.Errorf("Email = %q, want the principal name", userInfo.Email)
+	}
+}
@@ -0,0 +1,332 @@
+package oauth
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/utils"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// oidcHTTPTimeout bounds the discovery and JWKS requests
+	oidcHTTPTimeout = 10 * time.Second
+	// jwksCacheTTL is how long the signing keys are kept before being fetched again
+	jwksCacheTTL = time.Hour
+	// jwksMinRefresh limits how often an unknown key ID can trigger a refetch
+	jwksMinRefresh = time.Minute
+)
+
+var (
+	ErrIDTokenMissing    = errors.New("oidc: token response has no id_token")
+	ErrIDTokenInvalid    = errors.New("oidc: id_token is invalid")
+	ErrNonceMismatch     = errors.New("oidc: id_token nonce does not match")
+	ErrNonceRequired     = errors.New("oidc: id_token verification requires the login nonce")
+	ErrUnknownSigningKey = errors.New("oidc: id_token is signed with an unknown key")
+)
+
+// IDTokenVerifier is implemented by providers that sign users in with an OpenID Connect ID token.
+// The nonce sent in the authorization request must be found back in the ID token.
+type IDTokenVerifier interface {
+	VerifyIDToken(ctx context.Context, token *oauth2.Token, nonce string) (*utils.UserInfo, error)
+}
+
+// oidcDiscovery is the subset of /.well-known/openid-configuration used by the provider
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// idTokenClaims are the ID token claims mapped into utils.UserInfo
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+}
+
+type oidcProvider struct {
+	baseProvider
+	issuer      string
+	jwksURI     string
+	signingAlgs []string
+	client      *http.Client
+
+	keysMu      sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// NewOIDCProvider builds a provider from the discovery document of cfg.IssuerURL
+func NewOIDCProvider(cfg config.OAuthConfig) (Provider, error) {
+	if cfg.IssuerURL == "" {
+		return nil, fmt.Errorf("oidc: issuer URL is missing for %s", cfg.Provider)
+	}
+
+	client := &http.Client{Timeout: oidcHTTPTimeout}
+	issuer := strings.TrimSuffix(cfg.IssuerURL, "/")
+
+	var discovery oidcDiscovery
+	if err := fetchJSON(context.Background(), client, issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("oidc: discovery failed: %w", err)
+	}
+
+	// The issuer advertised must be the one configured, see OpenID Connect Discovery 4.3
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc: issuer mismatch, expected %s got %s", issuer, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery document of %s is incomplete", issuer)
+	}
+
+	signingAlgs := discovery.SigningAlgs
+	if len(signingAlgs) == 0 {
+		signingAlgs = []string{"RS256"}
+	}
+
+	return &oidcProvider{
+		baseProvider: baseProvider{
+			cfg: cfg,
+			endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+			defaultScopes: []string{"openid", "profile", "email"},
+		},
+		issuer:      discovery.Issuer,
+		jwksURI:     discovery.JWKSURI,
+		signingAlgs: signingAlgs,
+		client:      client,
+	}, nil
+}
+
+// FetchUserInfo is not supported, the profile only comes from an ID token checked against the login nonce
+func (p *oidcProvider) FetchUserInfo(ctx context.Context, token *oauth2.Token) (*utils.UserInfo, error) {
+	return nil, ErrNonceRequired
+}
+
+// VerifyIDToken checks the signature, iss, aud, exp and nonce of the ID token and maps its claims
+func (p *oidcProvider) VerifyIDToken(ctx context.Context, token *oauth2.Token, nonce string) (*utils.UserInfo, error) {
+	if nonce == "" {
+		return nil, ErrNonceRequired
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrIDTokenMissing
+	}
+
+	var claims idTokenClaims
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims,
+		func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			return p.signingKey(ctx, kid)
+		},
+		jwt.WithValidMethods(p.signingAlgs),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIDTokenInvalid, err)
+	}
+
+	// With several audiences the token must have been issued to us, see OpenID Connect Core 3.1.3.7
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, fmt.Errorf("%w: unexpected authorized party %q", ErrIDTokenInvalid, claims.AuthorizedParty)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+
+	return &utils.UserInfo{
+		ID:                claims.Subject,
+		Name:              name,
+		Email:             claims.Email,
+		Image:             claims.Picture,
+		EmailVerified:     claims.EmailVerified,
+		Provider:          p.Name(),
+		ProviderAccountID: claims.Subject,
+		AccessToken:       token.AccessToken,
+	}, nil
+}
+
+// signingKey returns the cached key for kid, refetching the JWKS when it is stale or the key is unknown
+func (p *oidcProvider) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.keysMu.RLock()
+	key, found := p.lookupKey(kid)
+	stale := time.Since(p.keysFetched) > jwksCacheTTL
+	canRefresh := time.Since(p.keysFetched) > jwksMinRefresh
+	p.keysMu.RUnlock()
+
+	if found && !stale {
+		return key, nil
+	}
+	if !canRefresh {
+		if found {
+			return key, nil
+		}
+		return nil, ErrUnknownSigningKey
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		if found {
+			return key, nil
+		}
+		return nil, err
+	}
+
+	p.keysMu.RLock()
+	defer p.keysMu.RUnlock()
+	if key, found := p.lookupKey(kid); found {
+		return key, nil
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+// lookupKey finds a key by ID, a token without kid is accepted when the set has a single key
+func (p *oidcProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *oidcProvider) refreshKeys(ctx context.Context) error {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := fetchJSON(ctx, p.client, p.jwksURI, &set); err != nil {
+		return fmt.Errorf("oidc: failed fetching JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue // Skip key types we can't use rather than failing the whole set
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.keysMu.Lock()
+	p.keys = keys
+	p.keysFetched = time.Now()
+	p.keysMu.Unlock()
+
+	return nil
+}
+
+// jsonWebKey is a public key from a JWKS, see RFC 7517
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// fetchJSON sends an unauthenticated GET request and decodes the response
+func fetchJSON(ctx context.Context, client *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
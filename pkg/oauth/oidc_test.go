@@ -0,0 +1,208 @@
+package oauth
+
+import (
+	"backend/pkg/config"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// stubIssuer stands in for an OpenID Connect issuer serving its discovery document and signing keys.
+// advertised is the issuer of the discovery document, the server URL when empty.
+func stubIssuer(t *testing.T, key *rsa.PrivateKey, advertised string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	if advertised == "" {
+		advertised = server.URL
+	}
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                advertised,
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         server.URL + "/token",
+			JWKSURI:               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jsonWebKey{{
+				Kty: "RSA",
+				Kid: "test-key",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	return server
+}
+
+func oidcConfig(issuerURL string) config.OAuthConfig {
+	cfg := testConfig("keycloak", nil)
+	cfg.IssuerURL = issuerURL
+	return cfg
+}
+
+func TestNewOIDCProviderDiscovery(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := stubIssuer(t, key, "")
+
+	provider, err := NewOIDCProvider(oidcConfig(server.URL + "/"))
+	if err != nil {
+		t.Fatalf("NewOIDCProvider: %v", err)
+	}
+	if got := provider.Endpoint(); got.AuthURL != server.URL+"/authorize" || got.TokenURL != server.URL+"/token" {
+		t.Errorf("Endpoint() = %+v, want the discovered endpoints", got)
+	}
+
+	// The issuer advertised must be the one configured
+	other := stubIssuer(t, key, "https://evil.example.com")
+	if _, err := NewOIDCProvider(oidcConfig(other.URL)); err == nil {
+		t.Error("NewOIDCProvider accepted a discovery document of another issuer")
+	}
+	if _, err := NewOIDCProvider(oidcConfig("")); err == nil {
+		t.Error("NewOIDCProvider accepted a missing issuer URL")
+	}
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := stubIssuer(t, key, "")
+
+	provider, err := NewOIDCProvider(oidcConfig(server.URL))
+	if err != nil {
+		t.Fatalf("NewOIDCProvider: %v", err)
+	}
+	verifier := provider.(IDTokenVerifier)
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   server.URL,
+			"sub":   "user-1",
+			"aud":   "client-id",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": "nonce",
+			"email": "jane@example.com",
+			"name":  "Jane Doe",
+		}
+	}
+	sign := func(claims jwt.MapClaims, signingKey *rsa.PrivateKey) *oauth2.Token {
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		idToken.Header["kid"] = "test-key"
+		signed, err := idToken.SignedString(signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]interface{}{"id_token": signed})
+	}
+
+	userInfo, err := verifier.VerifyIDToken(context.Background(), sign(validClaims(), key), "nonce")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if userInfo.ProviderAccountID != "user-1" || userInfo.Email != "jane@example.com" || userInfo.Name != "Jane Doe" {
+		t.Errorf("VerifyIDToken() = %+v", userInfo)
+	}
+
+	tests := []struct {
+		name    string
+		token   *oauth2.Token
+		nonce   string
+		wantErr error
+	}{
+		{"wrong nonce", sign(validClaims(), key), "other", ErrNonceMismatch},
+		{"no nonce", sign(validClaims(), key), "", ErrNonceRequired},
+		{"no id_token", &oauth2.Token{AccessToken: "access"}, "nonce", ErrIDTokenMissing},
+		{"wrong signature", sign(validClaims(), otherKey), "nonce", ErrIDTokenInvalid},
+		{"wrong audience", sign(func() jwt.MapClaims { c := validClaims(); c["aud"] = "other"; return c }(), key), "nonce", ErrIDTokenInvalid},
+		{"wrong issuer", sign(func() jwt.MapClaims { c := validClaims(); c["iss"] = "https://evil.example.com"; return c }(), key), "nonce", ErrIDTokenInvalid},
+		{"expired", sign(func() jwt.MapClaims { c := validClaims(); c["exp"] = time.Now().Add(-time.Hour).Unix(); return c }(), key), "nonce", ErrIDTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifier.VerifyIDToken(context.Background(), tt.token, tt.nonce); !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyIDToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyIDTokenPicture(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := stubIssuer(t, key, "")
+
+	cfg := oidcConfig(server.URL)
+	provider, err := NewOIDCProvider(cfg)
+	if err != nil {
+		t.Fatalf("NewOIDCProvider: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		picture string
+	}{
+		{"with picture", "https://id.example.com/avatar.png"},
+		{"without picture", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"iss":            server.URL,
+				"sub":            "user-1",
+				"aud":            cfg.ClientID,
+				"exp":            time.Now().Add(time.Minute).Unix(),
+				"nonce":          "nonce",
+				"email":          "jane@example.com",
+				"email_verified": true,
+				"name":           "Jane Doe",
+				"picture":        tt.picture,
+			})
+			idToken.Header["kid"] = "test-key"
+			signed, err := idToken.SignedString(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token := (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]interface{}{"id_token": signed})
+
+			userInfo, err := provider.(IDTokenVerifier).VerifyIDToken(context.Background(), token, "nonce")
+			if err != nil {
+				t.Fatalf("VerifyIDToken: %v", err)
+			}
+			if userInfo.Image != tt.picture {
+				t.Errorf("Image = %q, want %q", userInfo.Image, tt.picture)
+			}
+			if userInfo.Email != "jane@example.com" || !userInfo.EmailVerified {
+				t.Errorf("email = %q verified = %v", userInfo.Email, userInfo.EmailVerified)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package oauth
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrEmailUnavailable is returned when the provider has no usable email for the user, users are found by email
+var ErrEmailUnavailable = errors.New("oauth: the provider shared no usable email address")
+
+// Provider is an OAuth identity provider the users can sign in with
+type Provider interface {
+	// Name is the identifier used in routes and stored on accounts (e.g. "google")
+	Name() string
+	// Endpoint returns the authorization and token URLs of the provider
+	Endpoint() oauth2.Endpoint
+	// Scopes returns the scopes requested during the sign in
+	Scopes() []string
+	// UsePKCE reports whether a PKCE code challenge is sent to the provider
+	UsePKCE() bool
+	// OAuth2Config returns the client configuration used for the authorization code flow
+	OAuth2Config() oauth2.Config
+	// FetchUserInfo retrieves the profile of the signed in user and normalizes it
+	FetchUserInfo(ctx context.Context, token *oauth2.Token) (*utils.UserInfo, error)
+}
+
+// AuthCodeOptioner is implemented by providers that need extra parameters in the authorization request
+type AuthCodeOptioner interface {
+	AuthCodeOptions() []oauth2.AuthCodeOption
+}
+
+// Factory builds a provider from its configuration
+type Factory func(cfg config.OAuthConfig) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+	settings    = make(map[string][]string)
+)
+
+// Register makes a provider available to LoadRegistry, it is meant to be called from init.
+// Settings are the extra variables of the provider, found in OAuthConfig.Settings (e.g. "BASE_URL").
+func Register(name string, factory Factory, providerSettings ...string) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, exists := factories[name]; exists {
+		panic(fmt.Sprintf("oauth: provider %s registered twice", name))
+	}
+	factories[name] = factory
+	settings[name] = providerSettings
+}
+
+// Registry holds the providers enabled for this instance
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{providers: make(map[string]Provider)}
+	for _, p := range providers {
+		r.providers[p.Name()] = p
+	}
+	return r
+}
+
+// Registered returns the providers added with Register with their settings, to load their configuration
+func Registered() map[string][]string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	registered := make(map[string][]string, len(factories))
+	for name := range factories {
+		registered[name] = settings[name]
+	}
+	return registered
+}
+
+// setting returns a provider specific setting, or its default when unset
+func setting(cfg config.OAuthConfig, name, defaultValue string) string {
+	if value := cfg.Settings[name]; value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// LoadRegistry builds every registered provider that has a client ID configured,
+// followed by the generic OpenID Connect providers. It is meant to be called once at startup,
+// the OIDC providers fetch their discovery document and cache their signing keys.
+func LoadRegistry(providers, oidcProviders config.OAuthProviders) *Registry {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	registry := NewRegistry()
+	for name, cfg := range providers {
+		if factory, ok := factories[name]; ok {
+			registry.load(cfg, factory)
+		}
+	}
+	for name, cfg := range oidcProviders {
+		if _, exists := registry.providers[name]; exists || factories[name] != nil {
+			log.Printf("OIDC provider %s conflicts with a built-in provider, skipping", name)
+			continue
+		}
+		registry.load(cfg, NewOIDCProvider)
+	}
+
+	return registry
+}
+
+func (r *Registry) load(cfg config.OAuthConfig, factory Factory) {
+	if cfg.ClientID == "" {
+		log.Printf("OAuth provider %s has no client ID, skipping", cfg.Provider)
+		return
+	}
+
+	provider, err := factory(cfg)
+	if err != nil {
+		log.Printf("OAuth provider %s could not be loaded: %v", cfg.Provider, err)
+		return
+	}
+	r.providers[cfg.Provider] = provider
+}
+
+// Get returns the provider registered under name
+func (r *Registry) Get(name string) (Provider, error) {
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", name)
+	}
+	return provider, nil
+}
+
+// Names returns the names of the enabled providers
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// baseProvider implements the configuration part of Provider, shared by every provider
+type baseProvider struct {
+	cfg           config.OAuthConfig
+	endpoint      oauth2.Endpoint
+	defaultScopes []string
+}
+
+func (p *baseProvider) Name() string {
+	return p.cfg.Provider
+}
+
+func (p *baseProvider) Endpoint() oauth2.Endpoint {
+	return p.endpoint
+}
+
+func (p *baseProvider) Scopes() []string {
+	if len(p.cfg.Scopes) > 0 {
+		return p.cfg.Scopes
+	}
+	return p.defaultScopes
+}
+
+func (p *baseProvider) UsePKCE() bool {
+	return p.cfg.UsePKCE
+}
+
+func (p *baseProvider) OAuth2Config() oauth2.Config {
+	return oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.Scopes(),
+		Endpoint:     p.endpoint,
+	}
+}
+
+// getJSON sends an authenticated GET request to a provider API and decodes the response
+func getJSON(ctx context.Context, url, accessToken string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed getting user info: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed getting user info: unexpected status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed decoding user info: %v", err)
+	}
+
+	return nil
+}
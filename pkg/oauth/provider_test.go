@@ -0,0 +1,72 @@
+package oauth
+
+import (
+	"backend/pkg/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+var testToken = &oauth2.Token{AccessToken: "test-access-token", TokenType: "Bearer"}
+
+// stubAPI stands in for a provider API, it answers the requests made with testToken
+// with the JSON of responses, keyed by path
+func stubAPI(t *testing.T, responses map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken.AccessToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testConfig(provider string, settings map[string]string) config.OAuthConfig {
+	if settings == nil {
+		settings = make(map[string]string)
+	}
+	return config.OAuthConfig{
+		Provider: provider,
+		ClientID: "client-id",
+		Settings: settings,
+	}
+}
+
+func TestLoadOAuthConfigReadsProviderSettings(t *testing.T) {
+	t.Setenv("GITLAB_CLIENT_ID", "client-id")
+	t.Setenv("GITLAB_BASE_URL", "https://gitlab.example.com")
+
+	configs := config.LoadOAuthConfig(map[string][]string{"gitlab": {"BASE_URL"}})
+	registry := LoadRegistry(configs, nil)
+
+	provider, err := registry.Get("gitlab")
+	if err != nil {
+		t.Fatalf("gitlab provider not loaded: %v", err)
+	}
+	if got, want := provider.Endpoint().AuthURL, "https://gitlab.example.com/oauth/authorize"; got != want {
+		t.Errorf("AuthURL = %q, want %q", got, want)
+	}
+}
+
+func TestRegisteredDeclaresSettings(t *testing.T) {
+	registered := Registered()
+	for name, want := range map[string]string{"gitlab": "BASE_URL", "microsoft": "TENANT"} {
+		settings, ok := registered[name]
+		if !ok || len(settings) != 1 || settings[0] != want {
+			t.Errorf("Registered()[%q] = %v, want [%s]", name, settings, want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import "github.com/gofiber/fiber/v2"
 type Response struct {
 	Success bool        `json:"success"`
 	Message string      `json:"message,omitempty"`
+	Code    string      `json:"code,omitempty"` // Machine readable reason of some errors
 	Data    interface{} `json:"data,omitempty"`
 }
 
@@ -21,3 +22,12 @@ func Error(c *fiber.Ctx, code int, message string) error {
 		Message: message,
 	})
 }
+
+// ErrorWithCode is Error with a reason code clients can act on
+func ErrorWithCode(c *fiber.Ctx, code int, reason, message string) error {
+	return c.Status(code).JSON(Response{
+		Success: false,
+		Message: message,
+		Code:    reason,
+	})
+}
@@ -0,0 +1,94 @@
+package security
+
+import (
+	"backend/pkg/config"
+	"backend/pkg/utils"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownEncryptionKey = errors.New("value is encrypted with a key missing from the keyring")
+
+// valuePrefix starts every value encrypted by the keyring, it tells them apart from values stored in clear
+const valuePrefix = "enc:v1:"
+
+// minSealedLength is the size of an empty AES-GCM plaintext sealed by utils.Encrypt, its nonce and tag
+const minSealedLength = 12 + 16
+
+// Keyring encrypts values with AES-GCM under its current key and decrypts them with any of its keys.
+// An encrypted value is "enc:v1:<key ID>:<base64 ciphertext>", so that it names the key it needs.
+// Values written before the prefix, "<key ID>:<base64 ciphertext>" or without a key ID under the
+// legacy key, are still decrypted.
+type Keyring struct {
+	currentID string
+	keys      map[string][]byte
+	legacyKey []byte // Values without a key ID were encrypted with ENCRYPTION_KEY before the keyring
+}
+
+func NewKeyring(cfg *config.Config) (*Keyring, error) {
+	for id, key := range cfg.EncryptionKeys {
+		if strings.Contains(id, ":") || id == "enc" {
+			return nil, fmt.Errorf("encryption key ID %q must not contain ':' nor be \"enc\"", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes", id)
+		}
+	}
+	if _, ok := cfg.EncryptionKeys[cfg.EncryptionKeyID]; !ok {
+		return nil, fmt.Errorf("encryption key %q is not in the keyring", cfg.EncryptionKeyID)
+	}
+
+	return &Keyring{
+		currentID: cfg.EncryptionKeyID,
+		keys:      cfg.EncryptionKeys,
+		legacyKey: cfg.EncryptionKey,
+	}, nil
+}
+
+// Encrypt seals plaintext with the current key
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	ciphertext, err := utils.Encrypt(k.keys[k.currentID], plaintext)
+	if err != nil {
+		return "", err
+	}
+	return valuePrefix + k.currentID + ":" + ciphertext, nil
+}
+
+// Decrypt opens a value produced by Encrypt, or by an earlier format, with any key of the keyring
+func (k *Keyring) Decrypt(value string) (string, error) {
+	id, ciphertext, found := strings.Cut(strings.TrimPrefix(value, valuePrefix), ":")
+	if !found {
+		return utils.Decrypt(k.legacyKey, value)
+	}
+
+	key, ok := k.keys[id]
+	if !ok {
+		return "", ErrUnknownEncryptionKey
+	}
+	return utils.Decrypt(key, ciphertext)
+}
+
+// IsEncrypted reports whether a value has the shape of a value encrypted by the keyring, whatever its key.
+// A value that isn't can only have been stored in clear, before its column was encrypted.
+func (k *Keyring) IsEncrypted(value string) bool {
+	if strings.HasPrefix(value, valuePrefix) {
+		return true
+	}
+	if _, ciphertext, found := strings.Cut(value, ":"); found {
+		return isSealed(ciphertext)
+	}
+	return isSealed(value)
+}
+
+// IsCurrent reports whether a value is encrypted with the current key, the others are due for re-encryption
+func (k *Keyring) IsCurrent(value string) bool {
+	return strings.HasPrefix(value, valuePrefix+k.currentID+":")
+}
+
+// isSealed reports whether a value decodes to at least a nonce and a tag, like the output of utils.Encrypt
+func isSealed(value string) bool {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	return err == nil && len(sealed) >= minSealedLength
+}
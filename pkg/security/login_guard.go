@@ -0,0 +1,203 @@
+package security
+
+import (
+	"backend/pkg/config"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrLoginThrottled = errors.New("too many failed login attempts, please try again later")
+	ErrAccountLocked  = errors.New("too many failed login attempts, sign in is locked for a while")
+	ErrIPBlocked      = errors.New("too many failed login attempts from this address, sign in is blocked for a while")
+)
+
+// BlockedError is returned while an account or an IP has to wait before trying again
+type BlockedError struct {
+	Err        error // ErrLoginThrottled, ErrAccountLocked or ErrIPBlocked
+	RetryAfter time.Duration
+}
+
+func (e *BlockedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BlockedError) Unwrap() error {
+	return e.Err
+}
+
+// Lockout tells what a failure locked
+type Lockout struct {
+	Account bool // The account, or the second factor of a user
+	IP      bool
+}
+
+// Values of a block key
+const (
+	blockBackoff = "backoff"
+	blockLocked  = "locked"
+)
+
+// LoginGuard counts failed logins per account, wrong second factor codes per user and both per IP in Redis.
+// Past a few failures every attempt waits an exponentially growing delay, past the threshold the account,
+// its second factor or the IP is locked. Blocks expire on their own, Unlock lifts them early.
+type LoginGuard struct {
+	client             *redis.Client
+	maxAccountFailures int64
+	maxIPFailures      int64
+	backoffAfter       int64
+	backoffBase        time.Duration
+	backoffMax         time.Duration
+	failureWindow      time.Duration
+	lockoutDuration    time.Duration
+}
+
+func NewLoginGuard(client *redis.Client, cfg *config.Config) *LoginGuard {
+	policy := cfg.LoginProtection
+	return &LoginGuard{
+		client:             client,
+		maxAccountFailures: int64(policy.MaxAccountFailures),
+		maxIPFailures:      int64(policy.MaxIPFailures),
+		backoffAfter:       int64(policy.BackoffAfter),
+		backoffBase:        policy.BackoffBase,
+		backoffMax:         policy.BackoffMax,
+		failureWindow:      policy.FailureWindow,
+		lockoutDuration:    policy.LockoutDuration,
+	}
+}
+
+func failuresKey(scope, id string) string {
+	return fmt.Sprintf("login_failures:%s:%s", scope, id)
+}
+
+func blockKey(scope, id string) string {
+	return fmt.Sprintf("login_block:%s:%s", scope, id)
+}
+
+// normalizeEmail makes "Bob@Example.com" and "bob@example.com" share their counter
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Check returns a *BlockedError when the account or the IP has to wait, it must run before the password is checked
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) error {
+	return g.check(ctx, blockKey("account", normalizeEmail(email)), ip)
+}
+
+// CheckSecondFactor is Check for the second factor of a user, it must run before the code is checked
+func (g *LoginGuard) CheckSecondFactor(ctx context.Context, userID, ip string) error {
+	return g.check(ctx, blockKey("mfa", userID), ip)
+}
+
+func (g *LoginGuard) check(ctx context.Context, accountKey, ip string) error {
+	for _, key := range []string{accountKey, blockKey("ip", ip)} {
+		lockedErr := ErrAccountLocked
+		if key != accountKey {
+			lockedErr = ErrIPBlocked
+		}
+
+		pipe := g.client.Pipeline()
+		value := pipe.Get(ctx, key)
+		ttl := pipe.PTTL(ctx, key)
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		if value.Err() != nil || ttl.Val() <= 0 {
+			continue
+		}
+
+		blocked := &BlockedError{Err: ErrLoginThrottled, RetryAfter: ttl.Val()}
+		if value.Val() == blockLocked {
+			blocked.Err = lockedErr
+		}
+		return blocked
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login and blocks the account and the IP when needed.
+// It reports what this failure locked.
+func (g *LoginGuard) RecordFailure(ctx context.Context, email, ip string) (Lockout, error) {
+	return g.recordFailure(ctx, "account", normalizeEmail(email), ip)
+}
+
+// RecordSecondFactorFailure counts a wrong second factor code. Its counter is kept apart from the
+// password one, so signing in with the password again doesn't give more guesses.
+func (g *LoginGuard) RecordSecondFactorFailure(ctx context.Context, userID, ip string) (Lockout, error) {
+	return g.recordFailure(ctx, "mfa", userID, ip)
+}
+
+func (g *LoginGuard) recordFailure(ctx context.Context, scope, accountID, ip string) (Lockout, error) {
+	var lockout Lockout
+
+	pipe := g.client.TxPipeline()
+	accountFailures := pipe.Incr(ctx, failuresKey(scope, accountID))
+	pipe.Expire(ctx, failuresKey(scope, accountID), g.failureWindow)
+	ipFailures := pipe.Incr(ctx, failuresKey("ip", ip))
+	pipe.Expire(ctx, failuresKey("ip", ip), g.failureWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return lockout, err
+	}
+
+	// Like the account counter below, the IP counter starts over once its lock expires
+	if ipFailures.Val() >= g.maxIPFailures {
+		pipe := g.client.TxPipeline()
+		pipe.Set(ctx, blockKey("ip", ip), blockLocked, g.lockoutDuration)
+		pipe.Del(ctx, failuresKey("ip", ip))
+		if _, err := pipe.Exec(ctx); err != nil {
+			return lockout, err
+		}
+		lockout.IP = true
+	}
+
+	failures := accountFailures.Val()
+	switch {
+	case failures >= g.maxAccountFailures:
+		// The counter is cleared so the account gets a fresh start once the lock expires
+		pipe := g.client.TxPipeline()
+		pipe.Set(ctx, blockKey(scope, accountID), blockLocked, g.lockoutDuration)
+		pipe.Del(ctx, failuresKey(scope, accountID))
+		_, err := pipe.Exec(ctx)
+		lockout.Account = err == nil
+		return lockout, err
+	case failures >= g.backoffAfter:
+		return lockout, g.client.Set(ctx, blockKey(scope, accountID), blockBackoff, g.backoff(failures)).Err()
+	}
+	return lockout, nil
+}
+
+// RecordSuccess forgets the failures of an account after a successful login, the IP counter is kept
+func (g *LoginGuard) RecordSuccess(ctx context.Context, email string) error {
+	return g.client.Del(ctx, failuresKey("account", normalizeEmail(email))).Err()
+}
+
+// Unlock lifts the block of an account and clears its failures
+func (g *LoginGuard) Unlock(ctx context.Context, email string) error {
+	accountID := normalizeEmail(email)
+	return g.client.Del(ctx, blockKey("account", accountID), failuresKey("account", accountID)).Err()
+}
+
+// RecordSecondFactorSuccess forgets the wrong second factor codes of a user
+func (g *LoginGuard) RecordSecondFactorSuccess(ctx context.Context, userID string) error {
+	return g.client.Del(ctx, failuresKey("mfa", userID)).Err()
+}
+
+// UnlockSecondFactor lifts the second factor block of a user and clears its failures
+func (g *LoginGuard) UnlockSecondFactor(ctx context.Context, userID string) error {
+	return g.client.Del(ctx, blockKey("mfa", userID), failuresKey("mfa", userID)).Err()
+}
+
+// backoff doubles the delay for each failure past backoffAfter, up to backoffMax
+func (g *LoginGuard) backoff(failures int64) time.Duration {
+	delay := g.backoffBase
+	for i := g.backoffAfter; i < failures && delay < g.backoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, g.backoffMax)
+}
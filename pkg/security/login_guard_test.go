@@ -0,0 +1,187 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestGuard(t *testing.T, maxAccountFailures, maxIPFailures, backoffAfter int64) (*LoginGuard, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return &LoginGuard{
+		client:             client,
+		maxAccountFailures: maxAccountFailures,
+		maxIPFailures:      maxIPFailures,
+		backoffAfter:       backoffAfter,
+		backoffBase:        time.Second,
+		backoffMax:         4 * time.Second,
+		failureWindow:      15 * time.Minute,
+		lockoutDuration:    15 * time.Minute,
+	}, server
+}
+
+// fail records n failed logins and returns the lockout of the last one
+func fail(t *testing.T, guard *LoginGuard, n int, email, ip string) Lockout {
+	t.Helper()
+	var lockout Lockout
+	for i := 0; i < n; i++ {
+		var err error
+		if lockout, err = guard.RecordFailure(context.Background(), email, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lockout
+}
+
+// blockedBy returns the error wrapped by the *BlockedError of err, nil when err isn't one
+func blockedBy(t *testing.T, err error) (error, time.Duration) {
+	t.Helper()
+	if err == nil {
+		return nil, 0
+	}
+	var blocked *BlockedError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("Check() error = %v, want a *BlockedError", err)
+	}
+	return blocked.Err, blocked.RetryAfter
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	guard, _ := newTestGuard(t, 10, 100, 3)
+	ctx := context.Background()
+
+	fail(t, guard, 2, "jane@example.com", "10.0.0.1")
+	if err := guard.Check(ctx, "jane@example.com", "10.0.0.1"); err != nil {
+		t.Fatalf("Check() before the backoff = %v, want nil", err)
+	}
+
+	for failures, want := range map[int]time.Duration{3: time.Second, 4: 2 * time.Second, 5: 4 * time.Second, 6: 4 * time.Second} {
+		guard, _ := newTestGuard(t, 10, 100, 3)
+		fail(t, guard, failures, "jane@example.com", "10.0.0.1")
+
+		reason, retryAfter := blockedBy(t, guard.Check(ctx, "jane@example.com", "10.0.0.1"))
+		if !errors.Is(reason, ErrLoginThrottled) || retryAfter != want {
+			t.Errorf("after %d failures: %v for %v, want ErrLoginThrottled for %v", failures, reason, retryAfter, want)
+		}
+	}
+}
+
+func TestLoginGuardLocksTheAccount(t *testing.T) {
+	guard, server := newTestGuard(t, 3, 100, 10)
+	ctx := context.Background()
+
+	if lockout := fail(t, guard, 2, "jane@example.com", "10.0.0.1"); lockout.Account {
+		t.Fatal("the account was locked before the threshold")
+	}
+	if lockout := fail(t, guard, 1, "Jane@Example.com ", "10.0.0.2"); !lockout.Account || lockout.IP {
+		t.Fatalf("lockout = %+v, want the account only, the emails share a counter", lockout)
+	}
+
+	if reason, _ := blockedBy(t, guard.Check(ctx, "jane@example.com", "10.0.0.3")); !errors.Is(reason, ErrAccountLocked) {
+		t.Errorf("Check() = %v, want ErrAccountLocked from any IP", reason)
+	}
+	if err := guard.Check(ctx, "john@example.com", "10.0.0.1"); err != nil {
+		t.Errorf("Check() of another account = %v, want nil", err)
+	}
+
+	server.FastForward(15 * time.Minute)
+	if err := guard.Check(ctx, "jane@example.com", "10.0.0.1"); err != nil {
+		t.Errorf("Check() after the lockout = %v, want nil", err)
+	}
+	if lockout := fail(t, guard, 1, "jane@example.com", "10.0.0.1"); lockout.Account {
+		t.Error("the failures before the lockout still counted after it")
+	}
+}
+
+func TestLoginGuardUnlock(t *testing.T) {
+	guard, _ := newTestGuard(t, 3, 100, 10)
+	ctx := context.Background()
+
+	fail(t, guard, 3, "jane@example.com", "10.0.0.1")
+	if err := guard.Unlock(ctx, "JANE@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Check(ctx, "jane@example.com", "10.0.0.1"); err != nil {
+		t.Errorf("Check() after Unlock = %v, want nil", err)
+	}
+}
+
+// Failures spread over many accounts still add up for the IP they come from
+func TestLoginGuardBlocksTheIP(t *testing.T) {
+	guard, _ := newTestGuard(t, 10, 3, 10)
+	ctx := context.Background()
+
+	var lockout Lockout
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		lockout = fail(t, guard, 1, email, "10.0.0.1")
+	}
+	if !lockout.IP || lockout.Account {
+		t.Fatalf("lockout = %+v, want the IP only", lockout)
+	}
+
+	if reason, _ := blockedBy(t, guard.Check(ctx, "d@example.com", "10.0.0.1")); !errors.Is(reason, ErrIPBlocked) {
+		t.Errorf("Check() = %v, want ErrIPBlocked for any account", reason)
+	}
+	if err := guard.Check(ctx, "a@example.com", "10.0.0.2"); err != nil {
+		t.Errorf("Check() from another IP = %v, want nil", err)
+	}
+}
+
+func TestLoginGuardRecordSuccessKeepsTheIPCounter(t *testing.T) {
+	guard, _ := newTestGuard(t, 3, 3, 10)
+	ctx := context.Background()
+
+	fail(t, guard, 2, "jane@example.com", "10.0.0.1")
+	if err := guard.RecordSuccess(ctx, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if lockout := fail(t, guard, 1, "jane@example.com", "10.0.0.1"); lockout.Account || !lockout.IP {
+		t.Errorf("lockout = %+v, want the IP locked and the account counter reset", lockout)
+	}
+}
+
+// Signing in with the password again must not give more guesses of the second factor
+func TestLoginGuardSecondFactorIsCountedApart(t *testing.T) {
+	guard, _ := newTestGuard(t, 3, 100, 10)
+	ctx := context.Background()
+	const userID = "0b0d6f1e-5a4c-4d9b-9a3e-2f8b7c6d5e4f"
+
+	for i := 0; i < 2; i++ {
+		if _, err := guard.RecordSecondFactorFailure(ctx, userID, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := guard.RecordSuccess(ctx, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	lockout, err := guard.RecordSecondFactorFailure(ctx, userID, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lockout.Account {
+		t.Fatal("the third wrong code didn't lock the second factor")
+	}
+
+	if reason, _ := blockedBy(t, guard.CheckSecondFactor(ctx, userID, "10.0.0.1")); !errors.Is(reason, ErrAccountLocked) {
+		t.Errorf("CheckSecondFactor() = %v, want ErrAccountLocked", reason)
+	}
+	if err := guard.Check(ctx, "jane@example.com", "10.0.0.1"); err != nil {
+		t.Errorf("Check() of the password = %v, the second factor lock must not block it", err)
+	}
+
+	if err := guard.UnlockSecondFactor(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.CheckSecondFactor(ctx, userID, "10.0.0.1"); err != nil {
+		t.Errorf("CheckSecondFactor() after UnlockSecondFactor = %v, want nil", err)
+	}
+}
@@ -0,0 +1,255 @@
+package security
+
+import (
+	"backend/pkg/config"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicyError lists the rules a new password breaks
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy: " + strings.Join(e.Violations, ", ")
+}
+
+// BreachedPasswords tells whether a password appeared in a known data breach
+type BreachedPasswords interface {
+	Contains(password string) (bool, error)
+}
+
+// PasswordPolicy holds the rules a new password must follow
+type PasswordPolicy struct {
+	minLength          int
+	maxLength          int
+	requireUppercase   bool
+	requireLowercase   bool
+	requireDigit       bool
+	requireSymbol      bool
+	rejectPersonalInfo bool
+	breached           BreachedPasswords // nil when no list is configured
+}
+
+func NewPasswordPolicy(cfg *config.Config) (*PasswordPolicy, error) {
+	rules := cfg.PasswordPolicy
+	policy := &PasswordPolicy{
+		minLength:          rules.MinLength,
+		maxLength:          rules.MaxLength,
+		requireUppercase:   rules.RequireUppercase,
+		requireLowercase:   rules.RequireLowercase,
+		requireDigit:       rules.RequireDigit,
+		requireSymbol:      rules.RequireSymbol,
+		rejectPersonalInfo: rules.RejectPersonalInfo,
+	}
+
+	switch {
+	case rules.BreachedDir != "":
+		breached, err := NewBreachedPasswordDir(rules.BreachedDir)
+		if err != nil {
+			return nil, err
+		}
+		policy.breached = breached
+	case rules.BreachedFile != "":
+		breached, err := LoadBreachedPasswordFile(rules.BreachedFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.breached = breached
+	}
+
+	return policy, nil
+}
+
+// Check returns the rules password breaks, personal holds the email and name of its owner when known
+func (p *PasswordPolicy) Check(password string, personal ...string) []string {
+	var violations []string
+
+	length := utf8.RuneCountInString(password)
+	if p.minLength > 0 && length < p.minLength {
+		violations = append(violations, fmt.Sprintf("Must be at least %d characters long", p.minLength))
+	}
+	if p.maxLength > 0 && length > p.maxLength {
+		violations = append(violations, fmt.Sprintf("Must be at most %d characters long", p.maxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r):
+			hasSymbol = true
+		}
+	}
+	if p.requireUppercase && !hasUpper {
+		violations = append(violations, "Must contain an uppercase letter")
+	}
+	if p.requireLowercase && !hasLower {
+		violations = append(violations, "Must contain a lowercase letter")
+	}
+	if p.requireDigit && !hasDigit {
+		violations = append(violations, "Must contain a digit")
+	}
+	if p.requireSymbol && !hasSymbol {
+		violations = append(violations, "Must contain a symbol")
+	}
+
+	if p.rejectPersonalInfo && containsPersonalInfo(password, personal) {
+		violations = append(violations, "Must not contain your email or name")
+	}
+
+	if p.breached != nil && password != "" {
+		breached, err := p.breached.Contains(password)
+		if err != nil {
+			// A broken list must not prevent users from setting a password
+			log.Printf("Failed to check the breached password list: %v", err)
+		}
+		if breached {
+			violations = append(violations, "Appeared in a data breach, please choose another one")
+		}
+	}
+
+	return violations
+}
+
+// Validate is Check returning a *PasswordPolicyError
+func (p *PasswordPolicy) Validate(password string, personal ...string) error {
+	if violations := p.Check(password, personal...); len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// minPersonalInfoLength keeps short names like "Al" from rejecting most passwords
+const minPersonalInfoLength = 3
+
+// containsPersonalInfo looks for the values, the local part of emails and each word of names in password
+func containsPersonalInfo(password string, personal []string) bool {
+	password = strings.ToLower(password)
+
+	var needles []string
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		needles = append(needles, value)
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			needles = append(needles, local)
+		}
+		needles = append(needles, strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+	}
+
+	for _, needle := range needles {
+		if utf8.RuneCountInString(needle) >= minPersonalInfoLength && strings.Contains(password, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordSHA1 returns the uppercase hex SHA-1 used by breach lists
+func passwordSHA1(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// isSHA1Hex tells whether a list entry is a hash rather than a password
+func isSHA1Hex(value string) bool {
+	if len(value) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// breachedPasswordSet is a list small enough to be held in memory, as hashes
+type breachedPasswordSet map[string]struct{}
+
+// LoadBreachedPasswordFile reads a file with one password, or one SHA-1 hash optionally followed by ":COUNT", per line
+func LoadBreachedPasswordFile(path string) (BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	set := make(breachedPasswordSet)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			set[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		set[passwordSHA1(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+
+	return set, nil
+}
+
+func (s breachedPasswordSet) Contains(password string) (bool, error) {
+	_, ok := s[passwordSHA1(password)]
+	return ok, nil
+}
+
+// breachedPasswordDir looks passwords up in range files, only the file of the hash prefix is read
+type breachedPasswordDir struct {
+	dir string
+}
+
+// NewBreachedPasswordDir uses a directory of <PREFIX>.txt files, PREFIX being the first 5 hex characters
+// of the SHA-1 and each line holding "SUFFIX:COUNT", the layout of the Have I Been Pwned range API
+func NewBreachedPasswordDir(dir string) (BreachedPasswords, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password directory %s is not a directory", dir)
+	}
+	return &breachedPasswordDir{dir: dir}, nil
+}
+
+func (d *breachedPasswordDir) Contains(password string) (bool, error) {
+	hash := passwordSHA1(password)
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,136 @@
+package security
+
+import (
+	"backend/pkg/config"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestPolicy(t *testing.T, configure func(cfg *config.Config)) *PasswordPolicy {
+	t.Helper()
+	cfg := &config.Config{}
+	configure(cfg)
+	policy, err := NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestPasswordPolicyRules(t *testing.T) {
+	policy := newTestPolicy(t, func(cfg *config.Config) {
+		cfg.PasswordPolicy.MinLength = 8
+		cfg.PasswordPolicy.MaxLength = 16
+		cfg.PasswordPolicy.RequireUppercase = true
+		cfg.PasswordPolicy.RequireLowercase = true
+		cfg.PasswordPolicy.RequireDigit = true
+		cfg.PasswordPolicy.RequireSymbol = true
+	})
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"Tr0ub4dor&3", nil},
+		{"Sh0rt!", []string{"Must be at least 8 characters long"}},
+		{"Wáy-T00-Löng-Pässwörd", []string{"Must be at most 16 characters long"}},
+		{"tr0ub4dor&3", []string{"Must contain an uppercase letter"}},
+		{"TR0UB4DOR&3", []string{"Must contain a lowercase letter"}},
+		{"Troubador&x", []string{"Must contain a digit"}},
+		{"Tr0ub4dor33", []string{"Must contain a symbol"}},
+		// Runes are counted, not bytes
+		{"Pässwö1!", nil},
+	}
+	for _, tt := range tests {
+		if got := policy.Check(tt.password); !slices.Equal(got, tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordPolicyPersonalInfo(t *testing.T) {
+	policy := newTestPolicy(t, func(cfg *config.Config) {
+		cfg.PasswordPolicy.RejectPersonalInfo = true
+	})
+
+	personal := []string{"jane.doe@example.com", "Jane Al Doe"}
+	for _, password := range []string{"my-JANE-secret", "xjane.doex", "doe2024!", "jane.doe@example.com"} {
+		if err := policy.Validate(password, personal...); err == nil {
+			t.Errorf("Validate(%q) accepted a password containing personal info", password)
+		}
+	}
+	// "Al" is too short to be looked for
+	if err := policy.Validate("Always-correct", personal...); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestPasswordPolicyValidateError(t *testing.T) {
+	policy := newTestPolicy(t, func(cfg *config.Config) {
+		cfg.PasswordPolicy.MinLength = 12
+		cfg.PasswordPolicy.RequireDigit = true
+	})
+
+	var policyErr *PasswordPolicyError
+	if err := policy.Validate("short"); !errors.As(err, &policyErr) || len(policyErr.Violations) != 2 {
+		t.Errorf("Validate() = %v, want a *PasswordPolicyError with 2 violations", err)
+	}
+}
+
+func TestPasswordPolicyBreachedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	list := strings.Join([]string{
+		"password123",
+		// SHA-1 of "letmein", with a count
+		passwordSHA1("letmein") + ":42",
+		strings.ToLower(passwordSHA1("qwerty")),
+		"",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := newTestPolicy(t, func(cfg *config.Config) {
+		cfg.PasswordPolicy.BreachedFile = path
+	})
+
+	for password, want := range map[string]bool{"password123": true, "letmein": true, "qwerty": true, "Tr0ub4dor&3": false} {
+		if got := len(policy.Check(password)) > 0; got != want {
+			t.Errorf("Check(%q) breached = %v, want %v", password, got, want)
+		}
+	}
+}
+
+func TestPasswordPolicyBreachedDir(t *testing.T) {
+	dir := t.TempDir()
+	hash := passwordSHA1("letmein")
+	// Another suffix of the same range first, only the matching line counts
+	content := "0000000000000000000000000000000000A:1\r\n" + strings.ToLower(hash[5:]) + ":42\r\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := newTestPolicy(t, func(cfg *config.Config) {
+		cfg.PasswordPolicy.BreachedDir = dir
+	})
+
+	if violations := policy.Check("letmein"); len(violations) != 1 {
+		t.Errorf("Check() = %v, want the breach violation", violations)
+	}
+	// No range file for its prefix
+	if violations := policy.Check("Tr0ub4dor&3"); len(violations) != 0 {
+		t.Errorf("Check() = %v, want none", violations)
+	}
+}
+
+func TestNewPasswordPolicyMissingList(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.PasswordPolicy.BreachedDir = filepath.Join(t.TempDir(), "missing")
+	if _, err := NewPasswordPolicy(cfg); err == nil {
+		t.Error("NewPasswordPolicy() accepted a missing breached password directory")
+	}
+}
@@ -0,0 +1,55 @@
+package sessions
+
+import "strings"
+
+// userAgentMatch maps a user agent token to a readable name, the first match wins
+type userAgentMatch struct {
+	token string
+	name  string
+}
+
+// Order matters: Edge and Opera announce themselves as Chrome, Chrome as Safari
+var browsers = []userAgentMatch{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+}
+
+// Same for the systems: Android and iOS user agents mention Linux and Mac OS X
+var systems = []userAgentMatch{
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+// DeviceName turns a user agent into a short label such as "Firefox on Linux"
+func DeviceName(userAgent string) string {
+	browser := matchUserAgent(userAgent, browsers)
+	system := matchUserAgent(userAgent, systems)
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return "Unknown device"
+}
+
+func matchUserAgent(userAgent string, matches []userAgentMatch) string {
+	for _, match := range matches {
+		if strings.Contains(userAgent, match.token) {
+			return match.name
+		}
+	}
+	return ""
+}
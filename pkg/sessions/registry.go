@@ -0,0 +1,231 @@
+package sessions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Metadata describes where a session was opened, it is recorded by Track
+type Metadata struct {
+	UserAgent string
+	IP        string
+}
+
+// Info is a session as listed to its user. ID is a public identifier, not the session cookie.
+type Info struct {
+	ID           string
+	Device       string
+	UserAgent    string
+	IP           string
+	CreatedAt    time.Time
+	LastActivity time.Time
+}
+
+// Registry keeps an index of the sessions of each user in Redis,
+// so that all the sessions of a user can be found and revoked
+type Registry struct {
+	store  *session.Store
+	client *redis.Client
+}
+
+func NewRegistry(store *session.Store, client *redis.Client) *Registry {
+	return &Registry{
+		store:  store,
+		client: client,
+	}
+}
+
+func userKey(userID uuid.UUID) string {
+	return fmt.Sprintf("user_sessions:%s", userID)
+}
+
+func metadataKey(sessionID string) string {
+	return fmt.Sprintf("session_meta:%s", sessionID)
+}
+
+// PublicID identifies a session in the API without disclosing its cookie value
+func PublicID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Track adds a session to the index of its user and records where it was opened.
+// ttl is the lifetime of the session in the store.
+func (r *Registry) Track(ctx context.Context, userID uuid.UUID, sessionID string, metadata Metadata, ttl time.Duration) error {
+	key := userKey(userID)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	pipe := r.client.TxPipeline()
+	pipe.SAdd(ctx, key, sessionID)
+	extendIndex(ctx, pipe, key, ttl)
+	pipe.HSet(ctx, metadataKey(sessionID),
+		"user_agent", metadata.UserAgent,
+		"device", DeviceName(metadata.UserAgent),
+		"ip", metadata.IP,
+		"created_at", now,
+		"last_activity", now,
+	)
+	pipe.Expire(ctx, metadataKey(sessionID), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Touch records activity on a session and extends its index entries like the session itself
+func (r *Registry) Touch(ctx context.Context, userID uuid.UUID, sessionID, ip string, ttl time.Duration) error {
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, metadataKey(sessionID), "last_activity", time.Now().Unix(), "ip", ip)
+	pipe.Expire(ctx, metadataKey(sessionID), ttl)
+	extendIndex(ctx, pipe, userKey(userID), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// extendIndex makes the index of a user live as long as its longest session, sessions don't share a lifetime
+func extendIndex(ctx context.Context, pipe redis.Pipeliner, key string, ttl time.Duration) {
+	pipe.ExpireNX(ctx, key, ttl)
+	pipe.ExpireGT(ctx, key, ttl)
+}
+
+// Regenerate moves the data of a session to a new ID and destroys the old one, the caller saves it.
+// Every privilege change (login, second factor, logout) goes through it, so that an ID
+// known before the change, e.g. planted by a session fixation attack, is worthless after it.
+func (r *Registry) Regenerate(ctx context.Context, sess *session.Session) error {
+	if rawUserID, ok := sess.Get("user_id").(string); ok {
+		if userID, err := uuid.Parse(rawUserID); err == nil {
+			if err := r.Untrack(ctx, userID, sess.ID()); err != nil {
+				return err
+			}
+		}
+	}
+	return sess.Regenerate()
+}
+
+// Reset regenerates a session and drops its data, the caller saves it. A login starts from it, the data
+// stored before may have been planted along with the ID. No endpoint changes the role of a user yet,
+// one that does must reset the sessions of that user, or revoke them, for the same reason.
+func (r *Registry) Reset(ctx context.Context, sess *session.Session) error {
+	if err := r.Regenerate(ctx, sess); err != nil {
+		return err
+	}
+	for _, key := range sess.Keys() {
+		sess.Delete(key)
+	}
+	return nil
+}
+
+// Untrack removes a session from the index of its user, the session itself is left untouched
+func (r *Registry) Untrack(ctx context.Context, userID uuid.UUID, sessionID string) error {
+	pipe := r.client.TxPipeline()
+	pipe.SRem(ctx, userKey(userID), sessionID)
+	pipe.Del(ctx, metadataKey(sessionID))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// List returns the live sessions of a user, most recently active first.
+// Expired sessions found in the index are dropped from it.
+func (r *Registry) List(ctx context.Context, userID uuid.UUID) ([]Info, error) {
+	ids, err := r.client.SMembers(ctx, userKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]Info, 0, len(ids))
+	for _, id := range ids {
+		data, err := r.store.Storage.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			if err := r.Untrack(ctx, userID, id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		fields, err := r.client.HGetAll(ctx, metadataKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, Info{
+			ID:           PublicID(id),
+			Device:       fields["device"],
+			UserAgent:    fields["user_agent"],
+			IP:           fields["ip"],
+			CreatedAt:    unixField(fields["created_at"]),
+			LastActivity: unixField(fields["last_activity"]),
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].LastActivity.After(infos[j].LastActivity)
+	})
+	return infos, nil
+}
+
+// Revoke destroys the session of a user with the given public ID
+func (r *Registry) Revoke(ctx context.Context, userID uuid.UUID, publicID string) error {
+	ids, err := r.client.SMembers(ctx, userKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if PublicID(id) == publicID {
+			return r.destroy(ctx, userID, id)
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeAll destroys every session of a user except the ones listed in keep
+func (r *Registry) RevokeAll(ctx context.Context, userID uuid.UUID, keep ...string) error {
+	ids, err := r.client.SMembers(ctx, userKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[string]bool, len(keep))
+	for _, id := range keep {
+		kept[id] = true
+	}
+
+	for _, id := range ids {
+		if kept[id] {
+			continue
+		}
+		if err := r.destroy(ctx, userID, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) destroy(ctx context.Context, userID uuid.UUID, sessionID string) error {
+	if err := r.store.Delete(sessionID); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return r.Untrack(ctx, userID, sessionID)
+}
+
+// unixField parses a timestamp of the metadata hash, missing on sessions tracked before it existed
+func unixField(value string) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
@@ -0,0 +1,235 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	fiberredis "github.com/gofiber/storage/redis"
+	"github.com/google/uuid"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	server := miniredis.RunT(t)
+	port, err := strconv.Atoi(server.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := fiberredis.New(fiberredis.Config{Host: server.Host(), Port: port})
+	t.Cleanup(func() { _ = storage.Close() })
+	return NewRegistry(session.New(session.Config{Storage: storage}), storage.Conn())
+}
+
+// withSession runs fn on a session of the registry store, like a handler would
+func withSession(t *testing.T, registry *Registry, fn func(sess *session.Session)) {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		sess, err := registry.store.Get(c)
+		if err != nil {
+			return err
+		}
+		fn(sess)
+		return nil
+	})
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+}
+
+func TestResetDropsTheDataOfTheSession(t *testing.T) {
+	registry := newTestRegistry(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	withSession(t, registry, func(sess *session.Session) {
+		sess.Set("user_id", userID.String())
+		sess.Set("planted", "value")
+		oldID := sess.ID()
+		if err := registry.Track(ctx, userID, oldID, Metadata{}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := registry.Reset(ctx, sess); err != nil {
+			t.Fatalf("Reset() error = %v", err)
+		}
+
+		if sess.ID() == oldID {
+			t.Error("Reset() kept the session ID")
+		}
+		if keys := sess.Keys(); len(keys) != 0 {
+			t.Errorf("Reset() kept the keys %v", keys)
+		}
+		if ids, _ := registry.client.SMembers(ctx, userKey(userID)).Result(); len(ids) != 0 {
+			t.Errorf("the old ID is still tracked: %v", ids)
+		}
+	})
+}
+
+// newSavedSession stores a session of userID and tracks it like a login does, it returns its ID
+func newSavedSession(t *testing.T, registry *Registry, userID uuid.UUID, metadata Metadata) string {
+	t.Helper()
+	var id string
+	withSession(t, registry, func(sess *session.Session) {
+		sess.Set("user_id", userID.String())
+		id = sess.ID() // Save releases the session
+		if err := sess.Save(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := registry.Track(context.Background(), userID, id, metadata, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestTrackAndList(t *testing.T) {
+	registry := newTestRegistry(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	first := newSavedSession(t, registry, userID, Metadata{UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Firefox/120.0", IP: "10.0.0.1"})
+	second := newSavedSession(t, registry, userID, Metadata{IP: "10.0.0.2"})
+	newSavedSession(t, registry, uuid.New(), Metadata{})
+
+	// The second session is the most recently active one
+	if err := registry.client.HSet(ctx, metadataKey(first), "last_activity", time.Now().Add(-time.Minute).Unix()).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := registry.List(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("List() = %d sessions, want 2", len(infos))
+	}
+	if infos[0].ID != PublicID(second) || infos[1].ID != PublicID(first) {
+		t.Errorf("List() isn't sorted by last activity")
+	}
+	if infos[1].IP != "10.0.0.1" || infos[1].Device == "" || infos[1].CreatedAt.IsZero() {
+		t.Errorf("List() metadata = %+v", infos[1])
+	}
+	for _, info := range infos {
+		if info.ID == first || info.ID == second {
+			t.Error("List() disclosed a session cookie")
+		}
+	}
+}
+
+func TestListDropsExpiredSessions(t *testing.T) {
+	registry := newTestRegistry(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	expired := newSavedSession(t, registry, userID, Metadata{})
+	newSavedSession(t, registry, userID, Metadata{})
+	if err := registry.store.Delete(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := registry.List(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("List() = %d sessions, want 1", len(infos))
+	}
+	if tracked, _ := registry.client.SIsMember(ctx, userKey(userID), expired).Result(); tracked {
+		t.Error("the expired session is still in the index")
+	}
+}
+
+func TestRegenerateUntracksTheOldID(t *testing.T) {
+	registry := newTestRegistry(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	withSession(t, registry, func(sess *session.Session) {
+		sess.Set("user_id", userID.String())
+		oldID := sess.ID()
+		if err := registry.Track(ctx, userID, oldID, Metadata{}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := registry.Regenerate(ctx, sess); err != nil {
+			t.Fatalf("Regenerate() error = %v", err)
+		}
+		if sess.ID() == oldID {
+			t.Error("Regenerate() kept the session ID")
+		}
+		if sess.Get("user_id") != userID.String() {
+			t.Error("Regenerate() dropped the data of the session")
+		}
+		if tracked, _ := registry.client.SIsMember(ctx, userKey(userID), oldID).Result(); tracked {
+			t.Error("the old ID is still tracked")
+		}
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	registry := newTestRegistry(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	revoked := newSavedSession(t, registry, userID, Metadata{})
+	kept := newSavedSession(t, registry, userID, Metadata{})
+
+	if err := registry.Revoke(ctx, uuid.New(), PublicID(revoked)); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Revoke() by another user = %v, want ErrSessionNotFound", err)
+	}
+	if err := registry.Revoke(ctx, userID, PublicID(revoked)); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	assertSessions(t, registry, map[string]bool{revoked: false, kept: true})
+}
+
+func TestRevokeAll(t *testing.T) {
+	registry := newTestRegistry(t)
+	ctx := context.Background()
+	userID, otherUserID := uuid.New(), uuid.New()
+
+	current := newSavedSession(t, registry, userID, Metadata{})
+	first := newSavedSession(t, registry, userID, Metadata{})
+	second := newSavedSession(t, registry, userID, Metadata{})
+	other := newSavedSession(t, registry, otherUserID, Metadata{})
+
+	if err := registry.RevokeAll(ctx, userID, current); err != nil {
+		t.Fatalf("RevokeAll() error = %v", err)
+	}
+	assertSessions(t, registry, map[string]bool{current: true, first: false, second: false, other: true})
+
+	if err := registry.RevokeAll(ctx, userID); err != nil {
+		t.Fatalf("RevokeAll() error = %v", err)
+	}
+	assertSessions(t, registry, map[string]bool{current: false, other: true})
+	if ids, _ := registry.client.SMembers(ctx, userKey(userID)).Result(); len(ids) != 0 {
+		t.Errorf("the index still holds %v", ids)
+	}
+}
+
+// assertSessions checks which sessions are still in the store
+func assertSessions(t *testing.T, registry *Registry, want map[string]bool) {
+	t.Helper()
+	for id, alive := range want {
+		data, err := registry.store.Storage.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (data != nil) != alive {
+			t.Errorf("session %s alive = %v, want %v", PublicID(id), data != nil, alive)
+		}
+	}
+}
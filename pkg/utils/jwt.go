@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// AccessClaims are the claims carried by an access JWT, the subject is the user ID
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// GenerateAccessToken signs a short-lived HS256 access token for a user
+func GenerateAccessToken(secret string, userID uuid.UUID, email, role string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Email: email,
+		Role:  role,
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+// ParseAccessToken verifies the signature and expiry of an access token
+func ParseAccessToken(secret, tokenString string) (*AccessClaims, error) {
+	var claims AccessClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims,
+		func(t *jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	if _, err := uuid.Parse(claims.Subject); err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	return &claims, nil
+}
@@ -5,8 +5,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
 )
 
 type UserInfo struct {
@@ -15,15 +17,30 @@ type UserInfo struct {
 	Email string `json:"email"`
 	Image string `json:"picture,omitempty"`
 
+	// EmailVerified is true when the provider vouches the user owns Email
+	EmailVerified bool `json:"email_verified"`
+
 	// Account fields
 	ID                interface{} `json:"id"`
 	Provider          string      `json:"provider"`
 	ProviderAccountID string      `json:"provider_account_id"`
 	AccessToken       string      `json:"access_token"`
 	TokenType         string      `json:"token_type"`
+	RefreshToken      string      `json:"refresh_token,omitempty"`
+	Expiry            time.Time   `json:"expiry,omitempty"` // Zero when the access token doesn't expire
 	Scope             string      `json:"scope"`
 }
 
+// OAuth2Token rebuilds the provider token carried by the user info
+func (u *UserInfo) OAuth2Token() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  u.AccessToken,
+		TokenType:    u.TokenType,
+		RefreshToken: u.RefreshToken,
+		Expiry:       u.Expiry,
+	}
+}
+
 // GenerateRandomState creates a random state string for OAuth
 func GenerateRandomState() string {
 	b := make([]byte, 32)
@@ -45,69 +62,3 @@ func (u *UserInfo) GetProviderID() string {
 		return fmt.Sprintf("%v", v)
 	}
 }
-
-func GetUserInfoFromGoogle(accessToken string) (*UserInfo, error) {
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + accessToken)
-	if err != nil {
-		return nil, fmt.Errorf("failed getting user info: %v", err)
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		ID            interface{} `json:"id"`
-		Email         string      `json:"email"`
-		Name          string      `json:"name"`
-		Picture       string      `json:"picture"`
-		VerifiedEmail bool        `json:"verified_email"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed decoding user info: %v", err)
-	}
-
-	return &UserInfo{
-		ID:                result.ID,
-		Name:              result.Name,
-		Email:             result.Email,
-		Image:             result.Picture,
-		Provider:          "google",
-		ProviderAccountID: fmt.Sprint(result.ID),
-		AccessToken:       accessToken,
-	}, nil
-}
-
-func GetUserInfoFromDiscord(accessToken string) (*UserInfo, error) {
-	req, err := http.NewRequest("GET", "https://discord.com/api/users/@me", nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed creating request: %v", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed getting user info: %v", err)
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		ID            string `json:"id"`
-		Username      string `json:"username"`
-		Discriminator string `json:"discriminator"`
-		Avatar        string `json:"avatar"`
-		Email         string `json:"email"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed decoding user info: %v", err)
-	}
-
-	return &UserInfo{
-		ID:                result.ID,
-		Name:              result.Username, // + "#" + result.Discriminator,
-		Email:             result.Email,
-		Image:             fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", result.ID, result.Avatar),
-		Provider:          "discord",
-		ProviderAccountID: result.ID,
-		AccessToken:       accessToken,
-	}, nil
-}
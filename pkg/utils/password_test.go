@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters, the tests check the format and the comparisons, not the cost
+var testArgon2 = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestPasswordHasherArgon2id(t *testing.T) {
+	hasher := NewPasswordHasher(PasswordAlgorithmArgon2id, testArgon2, bcrypt.MinCost)
+
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash() = %q, want a PHC argon2id string", hash)
+	}
+
+	if match, needsRehash := hasher.Verify("correct horse", hash); !match || needsRehash {
+		t.Errorf("Verify() = %v, %v, want a match without rehash", match, needsRehash)
+	}
+	if match, _ := hasher.Verify("wrong horse", hash); match {
+		t.Error("Verify() matched a wrong password")
+	}
+
+	other, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Error("two hashes of the same password are equal, the salt isn't random")
+	}
+}
+
+func TestPasswordHasherRehash(t *testing.T) {
+	argon2Hasher := NewPasswordHasher(PasswordAlgorithmArgon2id, testArgon2, bcrypt.MinCost)
+	bcryptHasher := NewPasswordHasher(PasswordAlgorithmBcrypt, testArgon2, bcrypt.MinCost)
+
+	bcryptHash, err := bcryptHasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hash, err := argon2Hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := testArgon2
+	stronger.Iterations = 2
+	strongerHasher := NewPasswordHasher(PasswordAlgorithmArgon2id, stronger, bcrypt.MinCost)
+	costlierBcrypt := NewPasswordHasher(PasswordAlgorithmBcrypt, testArgon2, bcrypt.MinCost+1)
+
+	tests := []struct {
+		name            string
+		hasher          *PasswordHasher
+		hash            string
+		wantNeedsRehash bool
+	}{
+		{"bcrypt hash under argon2id", argon2Hasher, bcryptHash, true},
+		{"bcrypt hash under bcrypt", bcryptHasher, bcryptHash, false},
+		{"bcrypt hash under a higher cost", costlierBcrypt, bcryptHash, true},
+		{"argon2id hash under bcrypt", bcryptHasher, argon2Hash, true},
+		{"argon2id hash under stronger parameters", strongerHasher, argon2Hash, true},
+	}
+	for _, tt := range tests {
+		match, needsRehash := tt.hasher.Verify("correct horse", tt.hash)
+		if !match {
+			t.Errorf("%s: Verify() didn't match", tt.name)
+		}
+		if needsRehash != tt.wantNeedsRehash {
+			t.Errorf("%s: needsRehash = %v, want %v", tt.name, needsRehash, tt.wantNeedsRehash)
+		}
+		if match, needsRehash := tt.hasher.Verify("wrong horse", tt.hash); match || needsRehash {
+			t.Errorf("%s: Verify() of a wrong password = %v, %v", tt.name, match, needsRehash)
+		}
+	}
+}
+
+func TestPasswordHasherRejectsMalformedHashes(t *testing.T) {
+	hasher := NewPasswordHasher(PasswordAlgorithmArgon2id, testArgon2, bcrypt.MinCost)
+
+	for _, hash := range []string{
+		"",
+		"correct horse",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5",
+	} {
+		if match, _ := hasher.Verify("correct horse", hash); match {
+			t.Errorf("Verify() matched the malformed hash %q", hash)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// GenerateOpaqueToken creates a random URL-safe token, only its hash should be stored
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA-256 hex digest used to look up an opaque token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SignToken appends an HMAC of the token bound to its purpose, so a token
+// issued for one flow can't be replayed in another and forged ones are rejected without a lookup
+func SignToken(secret, purpose, token string) string {
+	return token + "." + tokenSignature(secret, purpose, token)
+}
+
+// VerifySignedToken checks a token produced by SignToken and returns the raw token
+func VerifySignedToken(secret, purpose, signed string) (string, bool) {
+	token, signature, found := strings.Cut(signed, ".")
+	if !found || token == "" {
+		return "", false
+	}
+
+	expected := tokenSignature(secret, purpose, token)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", false
+	}
+	return token, true
+}
+
+func tokenSignature(secret, purpose, token string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose + ":" + token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}